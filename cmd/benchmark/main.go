@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"text/tabwriter"
@@ -13,24 +14,36 @@ import (
 )
 
 func main() {
-	var configFile string
+	var configFile, resultsFormat string
 	var mergeF1, mergeF2 string
 	var threads int
-	var load, maxInFlightR, maxInFlightW int64
+	var load, maxInFlightR, maxInFlightW, seed int64
 	flag.StringVar(&configFile, "c", "noArg", "configuration file")
 	flag.IntVar(&threads, "t", 1, "number of client threads to be used")
 	flag.Int64Var(&load, "l", 0, "target load to be offered")
 	flag.Int64Var(&maxInFlightR, "fr", 0, "max read operations in flight")
 	flag.Int64Var(&maxInFlightW, "fw", 0, "max write operations in flight")
+	flag.Int64Var(&seed, "s", 0, "seed of the random sources, overrides the configured one")
 	preload := flag.Bool("p", false, "preload")
+	verify := flag.Bool("verify", false, "verify: check that the database holds the preloaded dataset and exit")
 	merge := flag.Bool("m", false, "merge")
 	flag.StringVar(&mergeF1, "m1", "noArg", "trace file for merge 1")
 	flag.StringVar(&mergeF2, "m2", "noArg", "trace file for merge 2")
 	dryRun := flag.Bool("d", false, "dryRun: print configuration and exit")
 	test := flag.Bool("test", false, "test: do 1 operation for each op type")
+	schema := flag.Bool("schema", false, "schema: create the benchmark tables in the configured database and exit")
+	reset := flag.Bool("reset", false, "reset: truncate the benchmark tables in the configured database and exit")
+	fit := flag.Bool("fit", false, "fit: print the distribution histograms of the configured database as TOML and exit")
+	dump := flag.String("dump", "", "SQL dump (mysqldump output) that -fit loads into the configured database before computing the histograms")
+	binWidth := flag.Int64("binWidth", 10, "bin width of the histograms computed by -fit")
+	checkSamplers := flag.Bool("checkSamplers", false, "checkSamplers: report how closely the samplers follow the configured histograms and exit")
+	sweep := flag.Bool("sweep", false, "sweep: step the target load over the configured range to find the saturation point")
+	flag.StringVar(&resultsFormat, "o", "json", "format of the results file: json or csv")
 
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stdout, "usage: -c config_file -s system [-p]")
+		fmt.Fprintln(os.Stdout, "usage: -c config_file [-t threads] [-l load] [-s seed] [-p | -verify | -test | -d | -sweep | -schema | -reset | -fit [-dump file] | -checkSamplers]")
+		fmt.Fprintln(os.Stdout, "       -m -m1 file -m2 file")
+		fmt.Fprintln(os.Stdout, "the measured system is set by Benchmark.MeasuredSystem in the configuration file")
 		w := new(tabwriter.Writer)
 		w.Init(os.Stdout, 0, 10, 0, '\t', 0)
 		flag.VisitAll(func(f *flag.Flag) {
@@ -121,11 +134,57 @@ func main() {
 		return
 	}
 
-	if configFile == "noArg" {
+	if configFile == "noArg" || (resultsFormat != "json" && resultsFormat != "csv") {
 		flag.Usage()
 		return
 	}
 
+	if *schema || *reset {
+		if *schema {
+			if err := benchmark.Schema(configFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *reset {
+			if err := benchmark.Reset(configFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	if *checkSamplers {
+		if err := benchmark.CheckSamplers(configFile, seed, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *fit {
+		if err := benchmark.FitHistograms(configFile, *dump, *binWidth, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *sweep {
+		steps, err := benchmark.Sweep(configFile, threads, maxInFlightR, maxInFlightW, seed)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fS, err := os.Create("sweep.txt")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fS.Close()
+
+		if err := benchmark.PrintSweep(io.MultiWriter(os.Stdout, fS), steps); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fM, err := os.Create("measurements.txt")
 	if err != nil {
 		log.Fatal(err)
@@ -143,7 +202,13 @@ func main() {
 	}
 	defer fTWrite.Close()
 
-	bench, err := benchmark.NewBenchmark(configFile, *preload, threads, load, maxInFlightR, maxInFlightW, *dryRun, fM)
+	fTS, err := os.Create("timeseries.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fTS.Close()
+
+	bench, err := benchmark.NewBenchmark(configFile, *preload || *verify, threads, load, maxInFlightR, maxInFlightW, seed, *dryRun, fM)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -159,10 +224,16 @@ func main() {
 		return
 	}
 
-	if *preload {
-		err = bench.Preload()
-		if err != nil {
-			log.Fatal(err)
+	if *preload || *verify {
+		if *preload {
+			if err = bench.Preload(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *verify {
+			if err = bench.Verify(); err != nil {
+				log.Fatal(err)
+			}
 		}
 		return
 	}
@@ -171,9 +242,23 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = bench.PrintMeasurements(fM, fTRead, fTWrite)
+	res, err := bench.PrintMeasurements(fM, fTRead, fTWrite)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if err := bench.PrintTimeSeries(fTS); err != nil {
+		log.Fatal(err)
+	}
+
+	fR, err := os.Create("results." + resultsFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fR.Close()
+
+	if err := res.Write(fR, resultsFormat); err != nil {
+		log.Fatal(err)
+	}
+
 }
@@ -2,7 +2,6 @@ package benchmark
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
 	"sync"
 	"time"
@@ -11,6 +10,7 @@ import (
 	"github.com/dvasilas/proteus-lobsters-bench/internal/generator"
 	getmetrics "github.com/dvasilas/proteus-lobsters-bench/internal/getMetrics"
 	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/results"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,13 +22,19 @@ type Benchmark struct {
 }
 
 // NewBenchmark ...
-func NewBenchmark(configFile string, preload bool, threadCnt int, load, maxInFlightR, maxInFlightW int64, dryRun bool, fM *os.File) (Benchmark, error) {
-	rand.Seed(time.Now().UnixNano())
-
+func NewBenchmark(configFile string, preload bool, threadCnt int, load, maxInFlightR, maxInFlightW, seed int64, dryRun bool, fM *os.File) (Benchmark, error) {
 	conf, err := config.GetConfig(configFile)
 	if err != nil {
 		return Benchmark{}, err
 	}
+	if seed != 0 {
+		conf.Benchmark.Seed = seed
+	}
+	if conf.Benchmark.Seed == 0 {
+		// the seed is part of the printed configuration,
+		// so that the run can be reproduced
+		conf.Benchmark.Seed = time.Now().UnixNano()
+	}
 	conf.Benchmark.DoPreload = preload
 	if threadCnt > 0 {
 		conf.Benchmark.ThreadCount = threadCnt
@@ -73,11 +79,11 @@ func (b Benchmark) Run() error {
 
 	for i := 0; i < b.config.Benchmark.ThreadCount; i++ {
 		wg.Add(1)
-		go func() {
+		go func(clientID int) {
 			defer wg.Done()
-			clientMeasurements := b.generator.Client()
+			clientMeasurements := b.generator.Client(clientID)
 			b.measurements.ReportMeasurements(clientMeasurements)
-		}()
+		}(i)
 	}
 
 	wg.Wait()
@@ -92,22 +98,49 @@ func (b Benchmark) Preload() error {
 	return b.generator.Preload()
 }
 
+// Verify ...
+func (b Benchmark) Verify() error {
+	return b.generator.Verify()
+}
+
 // Test ...
 func (b Benchmark) Test() error {
 	return b.generator.Test()
 }
 
-// PrintMeasurements ...
-func (b Benchmark) PrintMeasurements(fM, fTRead, fTWrite *os.File) error {
+// PrintMeasurements writes the measurements of the run to fM,
+// and returns them as a structured results document.
+func (b Benchmark) PrintMeasurements(fM, fTRead, fTWrite *os.File) (results.Results, error) {
 	if err := b.config.Print(fM); err != nil {
-		return err
+		return results.Results{}, err
 	}
 
 	metrics, err := b.measurements.CalculateMetrics(fTRead, fTWrite)
 	if err != nil {
-		return err
+		return results.Results{}, err
+	}
+
+	if err := printMetrics(fM, metrics); err != nil {
+		return results.Results{}, err
 	}
 
+	qpuMetrics, err := getmetrics.GetMetrics(*b.config)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("could not retrieve QPU metrics")
+	}
+	if err := getmetrics.PrintMetrics(qpuMetrics, fM); err != nil {
+		return results.Results{}, err
+	}
+
+	return results.New(*b.config, metrics, qpuMetrics), nil
+}
+
+// PrintTimeSeries writes the per-interval throughput and latency of the run to f.
+func (b Benchmark) PrintTimeSeries(f *os.File) error {
+	return b.measurements.TimeSeries().Print(f)
+}
+
+func printMetrics(fM *os.File, metrics measurements.Metrics) error {
 	if _, err := fmt.Fprintf(fM, "Runtime(s): %.3f\n", metrics.Runtime.Seconds()); err != nil {
 		return err
 	}
@@ -117,31 +150,96 @@ func (b Benchmark) PrintMeasurements(fM, fTRead, fTWrite *os.File) error {
 	if _, err := fmt.Fprintf(fM, "Total throughput: %.5f\n", metrics.Throughput); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintf(fM, "Aborted ops: %d\n", metrics.DeadlockAborts); err != nil {
+	if _, err := fmt.Fprintf(fM, "Failed ops: %d\n", metrics.TotalMetrics.ErrorCount); err != nil {
 		return err
 	}
-	for opType, metrics := range metrics.PerOpMetrics {
-		if _, err := fmt.Fprintf(fM, "[%s] Operation count: %d\n", opType, metrics.OpCount); err != nil {
+	for _, errKind := range measurements.ErrorKinds() {
+		if _, err := fmt.Fprintf(fM, "[errors] %s: %d\n", errKind, metrics.TotalMetrics.Errors[errKind.String()]); err != nil {
 			return err
 		}
-		if _, err := fmt.Fprintf(fM, "[%s] Throughput: %.5f\n", opType, metrics.Throughput); err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintf(fM, "[%s] p50(ms): %.5f\n", opType, metrics.P50); err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintf(fM, "[%s] p90(ms): %.5f\n", opType, metrics.P90); err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintf(fM, "[%s] p95(ms): %.5f\n", opType, metrics.P95); err != nil {
-			return err
+	}
+	if _, err := fmt.Fprintf(fM, "Mean schedule lag(ms): %.5f\n", durationToMillis(metrics.ScheduleLag.Mean())); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "Max schedule lag(ms): %.5f\n", durationToMillis(metrics.ScheduleLag.Max)); err != nil {
+		return err
+	}
+	if err := printOpMetrics(fM, "read", metrics.ReadMetrics); err != nil {
+		return err
+	}
+	if err := printOpMetrics(fM, "write", metrics.WriteMetrics); err != nil {
+		return err
+	}
+	for _, opKind := range measurements.OpKinds() {
+		opMetrics, ok := metrics.PerOpMetrics[opKind.String()]
+		if !ok {
+			continue
 		}
-		if _, err := fmt.Fprintf(fM, "[%s] p99(ms): %.5f\n", opType, metrics.P99); err != nil {
+		if err := printOpMetrics(fM, opKind.String(), opMetrics); err != nil {
 			return err
 		}
 	}
 
-	getmetrics.GetMetrics(*b.config, fM)
-
 	return nil
 }
+
+func printOpMetrics(fM *os.File, opType string, metrics measurements.OpMetrics) error {
+	if _, err := fmt.Fprintf(fM, "[%s] Operation count: %d\n", opType, metrics.OpCount); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] Throughput: %.5f\n", opType, metrics.Throughput); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] p50(ms): %.5f\n", opType, metrics.P50); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] p90(ms): %.5f\n", opType, metrics.P90); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] p95(ms): %.5f\n", opType, metrics.P95); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] p99(ms): %.5f\n", opType, metrics.P99); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] corrected p50(ms): %.5f\n", opType, metrics.CorrectedP50); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] corrected p90(ms): %.5f\n", opType, metrics.CorrectedP90); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] corrected p95(ms): %.5f\n", opType, metrics.CorrectedP95); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] corrected p99(ms): %.5f\n", opType, metrics.CorrectedP99); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] Error count: %d\n", opType, metrics.ErrorCount); err != nil {
+		return err
+	}
+	if metrics.ErrorCount == 0 {
+		return nil
+	}
+	for _, errKind := range measurements.ErrorKinds() {
+		if count, ok := metrics.Errors[errKind.String()]; ok {
+			if _, err := fmt.Fprintf(fM, "[%s] %s errors: %d\n", opType, errKind, count); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] error p50(ms): %.5f\n", opType, metrics.ErrorP50); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] error p90(ms): %.5f\n", opType, metrics.ErrorP90); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fM, "[%s] error p95(ms): %.5f\n", opType, metrics.ErrorP95); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(fM, "[%s] error p99(ms): %.5f\n", opType, metrics.ErrorP99)
+	return err
+}
+
+func durationToMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -20,72 +20,178 @@ const (
 	VoteTopStories DistributionType = iota
 )
 
+// Bin is a bin of a histogram: Count items have a value in
+// [Bin, Bin+width), the width being the distance to the next bin.
+type Bin struct {
+	Bin   int64 `json:"bin"`
+	Count int64 `json:"count"`
+}
+
 // BenchmarkConfig ...
 type BenchmarkConfig struct {
-	Tracing         bool
-	WorkerPoolSizeQ int
-	JobQueueSizeQ   int
-	WorkerPoolSizeW int
-	JobQueueSizeW   int
+	Tracing         bool `json:"tracing"`
+	WorkerPoolSizeQ int  `json:"workerPoolSizeQ"`
+	JobQueueSizeQ   int  `json:"jobQueueSizeQ"`
+	WorkerPoolSizeW int  `json:"workerPoolSizeW"`
+	JobQueueSizeW   int  `json:"jobQueueSizeW"`
 	Preload         struct {
+		// "rows" (the default) inserts one row per statement, "bulk" inserts
+		// BatchSize rows per statement
+		Mode      string `json:"mode"`
+		Threads   int    `json:"threads"`
+		BatchSize int    `json:"batchSize"`
+		// if the VotesPerStory (CommentsPerStory) histogram is configured,
+		// the number of votes (comments) follows from it, and Votes
+		// (Comments) is ignored
 		RecordCount struct {
-			Users    int64
-			Stories  int64
-			Comments int64
-			Votes    int64
-		}
-	}
+			Users    int64 `json:"users"`
+			Stories  int64 `json:"stories"`
+			Comments int64 `json:"comments"`
+			Votes    int64 `json:"votes"`
+		} `json:"recordCount"`
+	} `json:"preload"`
 	Operations struct {
 		Homepage struct {
-			StoriesLimit int
-		}
-		WriteRatio       float64
-		DownVoteRatio    float64
-		DistributionType string
-		VoteTopStoriesP  float64
-	}
+			StoriesLimit int `json:"storiesLimit"`
+		} `json:"homepage"`
+		Comments struct {
+			CommentsLimit int `json:"commentsLimit"`
+		} `json:"comments"`
+		WriteRatio       float64 `json:"writeRatio"`
+		DownVoteRatio    float64 `json:"downVoteRatio"`
+		DistributionType string  `json:"distributionType"`
+		VoteTopStoriesP  float64 `json:"voteTopStoriesP"`
+		// distributions of the stories that are voted, read and commented:
+		// "uniform", "histogram", "zipf", "hotspot" or "latest"
+		// "latest" moves to stories submitted during the run once they are
+		// inserted, so it is not reproducible with Benchmark.Seed.
+		// Story votes default to DistributionType, reads and comments to
+		// "histogram" (VotesPerStory and CommentsPerStory respectively).
+		StoryVoteDistribution    string `json:"storyVoteDistribution"`
+		StoryReadDistribution    string `json:"storyReadDistribution"`
+		CommentStoryDistribution string `json:"commentStoryDistribution"`
+		// the "zipf" and "latest" exponent, between 0 and 1
+		Zipf struct {
+			Theta float64 `json:"theta"`
+		} `json:"zipf"`
+		// "hotspot" sends OpFraction of the operations to KeyFraction of
+		// the stories
+		Hotspot struct {
+			KeyFraction float64 `json:"keyFraction"`
+			OpFraction  float64 `json:"opFraction"`
+		} `json:"hotspot"`
+		// stories submitted during the benchmark join the story
+		// distributions (except "latest") with InitialWeight, relative to
+		// an average preloaded story, which halves every HalfLife (s)
+		// 0 leaves them out.
+		// Stories join once they are inserted, so which ones get picked
+		// depends on the timing of the run, and is not reproducible with
+		// Benchmark.Seed.
+		NewStories struct {
+			InitialWeight float64 `json:"initialWeight"`
+			HalfLife      int     `json:"halfLife"`
+		} `json:"newStories"`
+		// users that vote, comment and submit stories: "uniform" (the
+		// default), or "histogram" (following Distributions.ActionsPerUser)
+		UserDistribution string `json:"userDistribution"`
+		// "allow" (the default): every vote counts, or "prevent": a user
+		// votes at most once per story or comment, as on Lobsters
+		// Votes through the Proteus and MySQL story vote endpoints are not
+		// attributed to users, so they are always allowed.
+		DuplicateVotes string `json:"duplicateVotes"`
+	} `json:"operations"`
 	Benchmark struct {
-		DoPreload        bool
-		DoWarmup         bool
-		Runtime          int
-		Warmup           int
-		ThreadCount      int
-		MeasuredSystem   string
-		TargetLoad       int64
-		WorkloadType     string
-		MaxInFlightRead  int64
-		MaxInFlightWrite int64
-	}
+		DoPreload        bool   `json:"doPreload"`
+		DoWarmup         bool   `json:"doWarmup"`
+		Runtime          int    `json:"runtime"`
+		Warmup           int    `json:"warmup"`
+		ThreadCount      int    `json:"threadCount"`
+		MeasuredSystem   string `json:"measuredSystem"`
+		TargetLoad       int64  `json:"targetLoad"`
+		WorkloadType     string `json:"workloadType"`
+		MaxInFlightRead  int64  `json:"maxInFlightRead"`
+		MaxInFlightWrite int64  `json:"maxInFlightWrite"`
+		// operations that take longer (ms) are cancelled and reported as
+		// timeouts, 0 disables the timeout
+		OpTimeout int `json:"opTimeout"`
+		// seed of the clients' random sources: a given seed and thread count
+		// produce the same op sequence, 0 picks a seed from the clock
+		// Stories submitted during the run are the exception: with
+		// Operations.NewStories, or the "latest" story distribution, the
+		// stories that ops target depend on the timing of the run.
+		Seed int64 `json:"seed"`
+		// interval (ms) used for time series sampling, defaults to 1s
+		TimeSeriesInterval int `json:"timeSeriesInterval"`
+		// "open" (default): ops are issued at TargetLoad
+		// "closed": Users simulated users each issue an op as soon as their
+		// previous one completes, after an optional think time
+		Mode      string `json:"mode"`
+		Users     int    `json:"users"`
+		ThinkTime struct {
+			// "constant", "uniform", "exponential", or empty for no think time
+			Distribution string `json:"distribution"`
+			// mean think time (ms)
+			Mean int `json:"mean"`
+		} `json:"thinkTime"`
+		Trace struct {
+			// if set, the generated operations are recorded to this file
+			Record string `json:"record"`
+			// trace replayed by the "replay" workload type, with its original
+			// timing, one client per recorded client
+			Replay string `json:"replay"`
+			// scales the recorded inter-arrival times (0.5 replays twice as
+			// fast), defaults to 1
+			TimeScale float64 `json:"timeScale"`
+		} `json:"trace"`
+	} `json:"benchmark"`
+	// Sweep steps the target load over [From, To] to find the saturation point
+	Sweep struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+		Step int64 `json:"step"`
+		// bisect [From, To] instead of stepping through it
+		Bisect bool `json:"bisect"`
+		// corrected p99 latency (ms) above which the system is considered
+		// saturated, 0 disables the check
+		SLO float64 `json:"slo"`
+		// fraction of the offered load by which throughput can fall short
+		// before the system is considered saturated
+		Tolerance float64 `json:"tolerance"`
+	} `json:"sweep"`
 	Connection struct {
-		ProteusEndpoints  []string
-		LobstersEndpoints []string
-		DBEndpoint        string
-		Database          string
-		AccessKeyID       string
-		SecretAccessKey   string
-		PoolSize          int
-		PoolOverflow      int
-	}
+		ProteusEndpoints  []string `json:"proteusEndpoints"`
+		LobstersEndpoints []string `json:"lobstersEndpoints"`
+		DBEndpoint        string   `json:"dbEndpoint"`
+		Database          string   `json:"database"`
+		AccessKeyID       string   `json:"-"`
+		SecretAccessKey   string   `json:"-"`
+		PoolSize          int      `json:"poolSize"`
+		PoolOverflow      int      `json:"poolOverflow"`
+		// "prepared" (default): datastore statements are prepared once at
+		// startup, "interpolated": arguments are formatted client-side
+		StatementMode string `json:"statementMode"`
+	} `json:"connection"`
 	GetMetrics struct {
 		QPU []struct {
-			Name     string
-			Endpoint string
-		}
-	}
+			Name     string `json:"name"`
+			Endpoint string `json:"endpoint"`
+		} `json:"qpu"`
+	} `json:"getMetrics"`
 	Distributions struct {
-		VotesPerStory []struct {
-			Bin   int64
-			Count int64
-		}
-		VotesPerComment []struct {
-			Bin   int64
-			Count int64
-		}
-		CommentsPerStory []struct {
-			Bin   int64
-			Count int64
-		}
-	}
+		// how samplers weigh the IDs of a bin: "fixed" (the default), or
+		// "average": by the average value of the bin, from Averages, or the
+		// middle of the bin if not set
+		SamplerMode string `json:"samplerMode"`
+		Averages    struct {
+			VotesPerStory    []float64 `json:"votesPerStory"`
+			VotesPerComment  []float64 `json:"votesPerComment"`
+			CommentsPerStory []float64 `json:"commentsPerStory"`
+		} `json:"averages"`
+		VotesPerStory    []Bin `json:"votesPerStory"`
+		VotesPerComment  []Bin `json:"votesPerComment"`
+		CommentsPerStory []Bin `json:"commentsPerStory"`
+		ActionsPerUser   []Bin `json:"actionsPerUser"`
+	} `json:"distributions"`
 }
 
 // GetConfig ...
@@ -118,7 +224,17 @@ func (c *BenchmarkConfig) Print(f *os.File) error {
 	if _, err := fmt.Fprintf(f, "Benchmark threads: %d\n", c.Benchmark.ThreadCount); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintf(f, "Target load: %d\n", c.Benchmark.TargetLoad*int64(c.Benchmark.ThreadCount)); err != nil {
+	if _, err := fmt.Fprintf(f, "Seed: %d\n", c.Benchmark.Seed); err != nil {
+		return err
+	}
+	if c.Benchmark.Mode == "closed" {
+		if _, err := fmt.Fprintf(f, "Closed loop users: %d\n", c.Benchmark.Users); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(f, "Think time: %s %d(ms)\n", c.Benchmark.ThinkTime.Distribution, c.Benchmark.ThinkTime.Mean); err != nil {
+			return err
+		}
+	} else if _, err := fmt.Fprintf(f, "Target load: %d\n", c.Benchmark.TargetLoad*int64(c.Benchmark.ThreadCount)); err != nil {
 		return err
 	}
 	if _, err := fmt.Fprintf(f, "Max in flight read: %d\n", c.Benchmark.MaxInFlightRead); err != nil {
@@ -127,15 +243,30 @@ func (c *BenchmarkConfig) Print(f *os.File) error {
 	if _, err := fmt.Fprintf(f, "Max in flight write: %d\n", c.Benchmark.MaxInFlightWrite); err != nil {
 		return err
 	}
+	if _, err := fmt.Fprintf(f, "Op timeout(ms): %d\n", c.Benchmark.OpTimeout); err != nil {
+		return err
+	}
 	if _, err := fmt.Fprintf(f, "Conn pool size: %d\n", c.Connection.PoolSize+c.Connection.PoolOverflow); err != nil {
 		return err
 	}
+	if _, err := fmt.Fprintf(f, "Statement mode: %s\n", c.Connection.StatementMode); err != nil {
+		return err
+	}
 	if _, err := fmt.Fprintf(f, "[workload] Q/W ratio(%%): %f\n", 1-c.Operations.WriteRatio); err != nil {
 		return err
 	}
 	if _, err := fmt.Fprintf(f, "[workload] U/D vote ratio(%%): %f\n", 1-c.Operations.DownVoteRatio); err != nil {
 		return err
 	}
+	if _, err := fmt.Fprintf(f, "[workload] Story distributions (vote/read/comment): %s/%s/%s\n", c.Operations.StoryVoteDistribution, c.Operations.StoryReadDistribution, c.Operations.CommentStoryDistribution); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "[preload] Mode: %s\n", c.Preload.Mode); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "[preload] Threads: %d\n", c.Preload.Threads); err != nil {
+		return err
+	}
 	if _, err := fmt.Fprintf(f, "[preload] Users: %d\n", c.Preload.RecordCount.Users); err != nil {
 		return err
 	}
@@ -145,6 +276,9 @@ func (c *BenchmarkConfig) Print(f *os.File) error {
 	if _, err := fmt.Fprintf(f, "[preload] Comments: %d\n", c.Preload.RecordCount.Comments); err != nil {
 		return err
 	}
+	if _, err := fmt.Fprintf(f, "[preload] Votes: %d\n", c.Preload.RecordCount.Votes); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -3,8 +3,10 @@ package datastore
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	//
@@ -14,16 +16,68 @@ import (
 // Datastore ...
 type Datastore struct {
 	Db *sql.DB
+	// prepared statements, by query text
+	// It is only written before the datastore is shared, in NewDatastore and
+	// Prepare.
+	stmts    map[string]*sql.Stmt
+	prepared bool
+	// UniqueVotes makes a user vote at most once for each story or comment,
+	// as on Lobsters: voting again in the same direction has no effect, and
+	// voting in the other direction changes the user's vote.
+	UniqueVotes bool
 }
 
+const (
+	insertStoryVote       = "INSERT INTO votes (story_id, vote, user_id) VALUES (?, ?, ?)"
+	updateStoryVoteSum    = "UPDATE stories SET vote_sum = vote_sum + ? WHERE id = ?"
+	insertCommentVote     = "INSERT INTO votes (comment_id, vote, user_id) VALUES (?, ?, ?)"
+	updateCommentVoteSum  = "UPDATE comments SET vote_sum = vote_sum + ? WHERE id = ?"
+	insertUser            = "INSERT INTO users (id, username) VALUES (?, ?)"
+	insertStory           = "INSERT INTO stories (id, user_id, title, description, short_id) VALUES (?, ?, ?, ?, ?)"
+	insertComment         = "INSERT INTO comments (user_id, story_id, comment) VALUES (?, ?, ?)"
+	updateComment         = "UPDATE comments SET comment = ? WHERE id = ?"
+	selectUserStoryVote   = "SELECT vote FROM votes WHERE user_id = ? AND story_id = ? FOR UPDATE"
+	updateUserStoryVote   = "UPDATE votes SET vote = ? WHERE user_id = ? AND story_id = ?"
+	selectUserCommentVote = "SELECT vote FROM votes WHERE user_id = ? AND comment_id = ? FOR UPDATE"
+	updateUserCommentVote = "UPDATE votes SET vote = ? WHERE user_id = ? AND comment_id = ?"
+)
+
+// voteStatements are the statements of a story or comment vote.
+type voteStatements struct {
+	insertVote     string
+	selectUserVote string
+	updateUserVote string
+	updateVoteSum  string
+}
+
+var (
+	storyVote = voteStatements{
+		insertVote:     insertStoryVote,
+		selectUserVote: selectUserStoryVote,
+		updateUserVote: updateUserStoryVote,
+		updateVoteSum:  updateStoryVoteSum,
+	}
+	commentVote = voteStatements{
+		insertVote:     insertCommentVote,
+		selectUserVote: selectUserCommentVote,
+		updateUserVote: updateUserCommentVote,
+		updateVoteSum:  updateCommentVoteSum,
+	}
+)
+
 // NewDatastore ...
-func NewDatastore(endpoint, datastoreDB, accessKeyID, secretAccessKey string) (Datastore, error) {
-	connStr := fmt.Sprintf("%s:%s@tcp(%s)/%s?interpolateParams=true",
-		accessKeyID,
-		secretAccessKey,
-		endpoint,
-		datastoreDB,
-	)
+// statementMode is either "prepared" (the default): statements are prepared
+// once, and reused by all workers, or "interpolated": the driver formats the
+// statement arguments client-side, and sends plain queries.
+func NewDatastore(endpoint, datastoreDB, accessKeyID, secretAccessKey, statementMode string) (Datastore, error) {
+	var prepared bool
+	switch statementMode {
+	case "", "prepared":
+		prepared = true
+	case "interpolated":
+	default:
+		return Datastore{}, errors.New("unknown statement mode")
+	}
 
 	for {
 		c, err := net.DialTimeout("tcp", endpoint, time.Second)
@@ -36,7 +90,7 @@ func NewDatastore(endpoint, datastoreDB, accessKeyID, secretAccessKey string) (D
 		}
 	}
 
-	db, err := sql.Open("mysql", connStr)
+	db, err := sql.Open("mysql", dataSourceName(endpoint, datastoreDB, accessKeyID, secretAccessKey, prepared))
 	if err != nil {
 		return Datastore{}, err
 	}
@@ -45,77 +99,240 @@ func NewDatastore(endpoint, datastoreDB, accessKeyID, secretAccessKey string) (D
 	db.SetMaxOpenConns(1024)
 	db.SetConnMaxLifetime(10 * time.Minute)
 
-	return Datastore{Db: db}, nil
+	return newDatastore(db, prepared)
 }
 
-// StoryVoteSimple ...
-func (ds Datastore) StoryVoteSimple(userID int, storyID int64, vote int) error {
-	query := fmt.Sprintf("INSERT INTO votes (story_id, vote, user_id) VALUES (%d, %d, %d)", storyID, vote, userID)
+// dataSourceName returns the MySQL DSN of the datastore.
+// Without prepared statements, the driver interpolates the arguments.
+func dataSourceName(endpoint, datastoreDB, accessKeyID, secretAccessKey string, prepared bool) string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?interpolateParams=%t",
+		accessKeyID,
+		secretAccessKey,
+		endpoint,
+		datastoreDB,
+		!prepared,
+	)
+}
+
+// newDatastore prepares the statements of the datastore on db, if prepared
+// is set.
+func newDatastore(db *sql.DB, prepared bool) (Datastore, error) {
+	ds := Datastore{
+		Db:       db,
+		stmts:    make(map[string]*sql.Stmt),
+		prepared: prepared,
+	}
+
+	err := ds.Prepare(
+		insertStoryVote,
+		updateStoryVoteSum,
+		insertCommentVote,
+		updateCommentVoteSum,
+		insertUser,
+		insertStory,
+		insertComment,
+		updateComment,
+		selectUserStoryVote,
+		updateUserStoryVote,
+		selectUserCommentVote,
+		updateUserCommentVote,
+	)
+
+	return ds, err
+}
+
+// Prepare prepares the given queries, if the datastore uses prepared
+// statements.
+// It needs to be called before the datastore is used concurrently.
+func (ds Datastore) Prepare(queries ...string) error {
+	if !ds.prepared {
+		return nil
+	}
+
+	for _, query := range queries {
+		if _, ok := ds.stmts[query]; ok {
+			continue
+		}
+		stmt, err := ds.Db.Prepare(query)
+		if err != nil {
+			return err
+		}
+		ds.stmts[query] = stmt
+	}
+
+	return nil
+}
+
+// Query runs a query, using its prepared statement if there is one.
+func (ds Datastore) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt, ok := ds.stmts[query]; ok {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return ds.Db.QueryContext(ctx, query, args...)
+}
+
+func (ds Datastore) exec(ctx context.Context, query string, args ...interface{}) error {
 	var err error
-	_, err = ds.Db.Exec(query)
+	if stmt, ok := ds.stmts[query]; ok {
+		_, err = stmt.ExecContext(ctx, args...)
+	} else {
+		_, err = ds.Db.ExecContext(ctx, query, args...)
+	}
 	return err
 }
 
+func (ds Datastore) txExec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) error {
+	_, err := ds.txExecResult(ctx, tx, query, args...)
+	return err
+}
+
+func (ds Datastore) txExecResult(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if stmt, ok := ds.stmts[query]; ok {
+		return tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	return tx.ExecContext(ctx, query, args...)
+}
+
+func (ds Datastore) txQueryRow(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	if stmt, ok := ds.stmts[query]; ok {
+		return tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+	}
+	return tx.QueryRowContext(ctx, query, args...)
+}
+
+// StoryVoteSimple ...
+func (ds Datastore) StoryVoteSimple(ctx context.Context, userID, storyID int64, vote int) error {
+	return ds.vote(ctx, storyVote, false, userID, storyID, vote)
+}
+
 // StoryVoteUpdateCount ...
-func (ds Datastore) StoryVoteUpdateCount(userID int, storyID int64, vote int) error {
-	ctx := context.Background()
-	tx, err := ds.Db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+func (ds Datastore) StoryVoteUpdateCount(ctx context.Context, userID, storyID int64, vote int) error {
+	return ds.vote(ctx, storyVote, true, userID, storyID, vote)
+}
+
+// CommentVoteSimple ...
+func (ds Datastore) CommentVoteSimple(ctx context.Context, userID, commentID int64, vote int) error {
+	return ds.vote(ctx, commentVote, false, userID, commentID, vote)
+}
+
+// CommentVoteUpdateCount ...
+func (ds Datastore) CommentVoteUpdateCount(ctx context.Context, userID, commentID int64, vote int) error {
+	return ds.vote(ctx, commentVote, true, userID, commentID, vote)
+}
+
+// vote inserts a vote and, if updateCount is set, updates the vote count of
+// the voted story or comment, in a transaction.
+// With UniqueVotes, an existing vote of the user is changed instead.
+func (ds Datastore) vote(ctx context.Context, stmts voteStatements, updateCount bool, userID, id int64, vote int) error {
+	if !updateCount && !ds.UniqueVotes {
+		return ds.exec(ctx, stmts.insertVote, id, vote, userID)
 	}
 
-	insertVote := fmt.Sprintf("INSERT INTO votes (story_id, vote, user_id) VALUES (%d, %d, %d)", storyID, vote, userID)
-	_, err = tx.ExecContext(ctx, insertVote)
+	tx, err := ds.Db.BeginTx(ctx, nil)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	selectStory := fmt.Sprintf("SELECT vote_sum FROM stories WHERE id = %d", storyID)
-	row := tx.QueryRow(selectStory)
-	var voteCount int64
-	err = row.Scan(&voteCount)
+	// the change in the vote count
+	delta := int64(vote)
+
+	if ds.UniqueVotes {
+		var prevVote int
+		err = ds.txQueryRow(ctx, tx, stmts.selectUserVote, userID, id).Scan(&prevVote)
+		switch {
+		case err == sql.ErrNoRows:
+			err = ds.txExec(ctx, tx, stmts.insertVote, id, vote, userID)
+		case err != nil:
+		case prevVote == vote:
+			return tx.Rollback()
+		default:
+			delta -= int64(prevVote)
+			err = ds.txExec(ctx, tx, stmts.updateUserVote, vote, userID, id)
+		}
+	} else {
+		err = ds.txExec(ctx, tx, stmts.insertVote, id, vote, userID)
+	}
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	updateStory := fmt.Sprintf("UPDATE stories SET vote_sum=%d WHERE id = %d", voteCount+int64(vote), storyID)
-	_, err = tx.ExecContext(ctx, updateStory)
-	if err != nil {
-		tx.Rollback()
-		return err
+	if updateCount {
+		// incremented in place, so that concurrent votes do not overwrite
+		// each other's count
+		res, err := ds.txExecResult(ctx, tx, stmts.updateVoteSum, delta, id)
+		if err == nil {
+			var n int64
+			if n, err = res.RowsAffected(); err == nil && n == 0 {
+				// there is no such story or comment
+				err = sql.ErrNoRows
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	return tx.Commit()
 }
 
 // Adduser ...
-func (ds Datastore) Adduser(username string) error {
-	query := fmt.Sprintf("INSERT INTO users (username) VALUES ('%s')", username)
-	var err error
-	_, err = ds.Db.Exec(query)
-	return err
+// The ID is given, as usernames are derived from it.
+func (ds Datastore) Adduser(ctx context.Context, id int64, username string) error {
+	return ds.exec(ctx, insertUser, id, username)
 }
 
 // Submit ...
-func (ds Datastore) Submit(userID int, title, description, shortID string) error {
-	query := fmt.Sprintf("INSERT INTO stories (user_id, title, description, short_id) VALUES (%d, '%s', '%s', '%s')", userID, title, description, shortID)
-	var err error
-	_, err = ds.Db.Exec(query)
-	return err
+// The ID is given, as short IDs are derived from it: with AUTO_INCREMENT,
+// concurrent or failed inserts would give stories another ID.
+func (ds Datastore) Submit(ctx context.Context, id, userID int64, title, description, shortID string) error {
+	return ds.exec(ctx, insertStory, id, userID, title, description, shortID)
 }
 
 // Comment ...
-func (ds Datastore) Comment(userID int, storyID int64, comment string) error {
-	query := fmt.Sprintf("INSERT INTO comments (user_id, story_id, comment) VALUES (%d, %d, '%s')", userID, storyID, comment)
-	var err error
-	_, err = ds.Db.Exec(query)
+func (ds Datastore) Comment(ctx context.Context, userID, storyID int64, comment string) error {
+	return ds.exec(ctx, insertComment, userID, storyID, comment)
+}
+
+// EditComment ...
+func (ds Datastore) EditComment(ctx context.Context, commentID int64, comment string) error {
+	return ds.exec(ctx, updateComment, comment, commentID)
+}
+
+// QueryInt runs a query that returns a single integer (0 if NULL).
+func (ds Datastore) QueryInt(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var v sql.NullInt64
+	err := ds.Db.QueryRowContext(ctx, query, args...).Scan(&v)
+	return v.Int64, err
+}
+
+// BulkInsert inserts rows into table with a single multi-row INSERT.
+// Each row holds a value for each of the given columns.
+func (ds Datastore) BulkInsert(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	placeholders := "(?" + strings.Repeat(", ?", len(columns)-1) + ")"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(placeholders)
+		args = append(args, row...)
+	}
+
+	_, err := ds.Db.ExecContext(ctx, b.String(), args...)
 	return err
 }
 
 // Get ...
-func (ds Datastore) Get(table, projection string, predicate map[string]interface{}) (interface{}, error) {
+func (ds Datastore) Get(ctx context.Context, table, projection string, predicate map[string]interface{}) (interface{}, error) {
 
 	whereStmt := ""
 	whereValues := make([]interface{}, len(predicate))
@@ -131,14 +348,14 @@ func (ds Datastore) Get(table, projection string, predicate map[string]interface
 	}
 
 	query := "SELECT " + projection + " FROM " + table + " WHERE " + whereStmt
-	stmtSelect, err := ds.Db.Prepare(query)
+	stmtSelect, err := ds.Db.PrepareContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmtSelect.Close()
 
 	var destValue interface{}
-	err = stmtSelect.QueryRow(whereValues...).Scan(&destValue)
+	err = stmtSelect.QueryRowContext(ctx, whereValues...).Scan(&destValue)
 
 	return destValue, err
 }
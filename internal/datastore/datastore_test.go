@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDatastore connects to the MySQL database given by the
+// LOBSTERS_TEST_DB_* environment variables, and empties it.
+// The test is skipped if LOBSTERS_TEST_DB_ENDPOINT is not set.
+func testDatastore(t *testing.T, statementMode string) Datastore {
+	endpoint := os.Getenv("LOBSTERS_TEST_DB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("LOBSTERS_TEST_DB_ENDPOINT is not set")
+	}
+
+	ds, err := NewDatastore(endpoint, os.Getenv("LOBSTERS_TEST_DB_DATABASE"), os.Getenv("LOBSTERS_TEST_DB_USER"), os.Getenv("LOBSTERS_TEST_DB_PASSWORD"), statementMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := ds.CreateSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestConcurrentVotes(t *testing.T) {
+	for _, mode := range []string{"prepared", "interpolated"} {
+		t.Run(mode, func(t *testing.T) {
+			ds := testDatastore(t, mode)
+			defer ds.Db.Close()
+			ctx := context.Background()
+
+			assert.NoError(t, ds.Adduser(ctx, 1, "user1"))
+			assert.NoError(t, ds.Submit(ctx, 1, 1, "story 1", "", "000001"))
+			assert.NoError(t, ds.Comment(ctx, 1, 1, "comment"))
+
+			const votes = 200
+			var wg sync.WaitGroup
+			for i := 0; i < votes; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, ds.StoryVoteUpdateCount(ctx, 1, 1, 1))
+					assert.NoError(t, ds.CommentVoteUpdateCount(ctx, 1, 1, 1))
+				}()
+			}
+			wg.Wait()
+
+			storyVotes, err := ds.QueryInt(ctx, "SELECT vote_sum FROM stories WHERE id = 1")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(votes), storyVotes)
+			commentVotes, err := ds.QueryInt(ctx, "SELECT vote_sum FROM comments WHERE id = 1")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(votes), commentVotes)
+
+			assert.Error(t, ds.StoryVoteUpdateCount(ctx, 1, 2, 1), "vote for a missing story")
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// LoadDump executes the statements of a SQL dump, as written by mysqldump,
+// against the database. The tables of the dump replace the existing ones.
+func (ds Datastore) LoadDump(ctx context.Context, r io.Reader) error {
+	return splitStatements(r, func(stmt string) error {
+		_, err := ds.Db.ExecContext(ctx, stmt)
+		return err
+	})
+}
+
+// splitStatements calls fn with each statement of a SQL dump, in order.
+// Statements end with a ';' at the end of a line, as mysqldump writes them;
+// comment lines are skipped. Dumps that change the delimiter, to define
+// routines or triggers, are not supported.
+func splitStatements(r io.Reader, fn func(stmt string) error) error {
+	br := bufio.NewReader(r)
+	var stmt strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "--") || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(strings.ToUpper(trimmed), "DELIMITER"):
+			return errors.New("SQL dumps that change the delimiter are not supported")
+		default:
+			stmt.WriteString(line)
+			if strings.HasSuffix(trimmed, ";") {
+				if err := fn(strings.TrimSpace(stmt.String())); err != nil {
+					return err
+				}
+				stmt.Reset()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		return errors.New("SQL dump ends in the middle of a statement")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dump    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "mysqldump",
+			dump: "-- MySQL dump 10.13\n" +
+				"--\n" +
+				"/*!40101 SET NAMES utf8mb4 */;\n" +
+				"\n" +
+				"DROP TABLE IF EXISTS `votes`;\n" +
+				"CREATE TABLE `votes` (\n" +
+				"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				");\n" +
+				"INSERT INTO `votes` VALUES (1),(2);\n",
+			want: []string{
+				"/*!40101 SET NAMES utf8mb4 */;",
+				"DROP TABLE IF EXISTS `votes`;",
+				"CREATE TABLE `votes` (\n  `id` bigint NOT NULL AUTO_INCREMENT,\n  PRIMARY KEY (`id`)\n);",
+				"INSERT INTO `votes` VALUES (1),(2);",
+			},
+		},
+		{
+			name: "no trailing newline",
+			dump: "INSERT INTO `votes` VALUES (1);",
+			want: []string{"INSERT INTO `votes` VALUES (1);"},
+		},
+		{
+			name: "empty",
+			dump: "-- nothing\n",
+		},
+		{
+			name:    "unterminated statement",
+			dump:    "INSERT INTO `votes` VALUES (1)\n",
+			wantErr: true,
+		},
+		{
+			name:    "delimiter",
+			dump:    "DELIMITER ;;\nCREATE TRIGGER t BEFORE INSERT ON votes FOR EACH ROW BEGIN END;;\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			err := splitStatements(strings.NewReader(tc.dump), func(stmt string) error {
+				got = append(got, stmt)
+				return nil
+			})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
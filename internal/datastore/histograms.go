@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"context"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+)
+
+// queries that count the votes or comments of each story or comment,
+// including the ones that have none
+// Comment votes also carry the story ID on Lobsters, so story votes are the
+// votes without a comment ID.
+const (
+	votesPerStoryQuery    = "SELECT COUNT(v.id) AS n FROM stories s LEFT JOIN votes v ON v.story_id = s.id AND v.comment_id IS NULL GROUP BY s.id"
+	votesPerCommentQuery  = "SELECT COUNT(v.id) AS n FROM comments c LEFT JOIN votes v ON v.comment_id = c.id GROUP BY c.id"
+	commentsPerStoryQuery = "SELECT COUNT(c.id) AS n FROM stories s LEFT JOIN comments c ON c.story_id = s.id GROUP BY s.id"
+)
+
+// VotesPerStory computes the histogram of the number of votes of each story,
+// with bins of the given width.
+func (ds Datastore) VotesPerStory(ctx context.Context, binWidth int64) ([]config.Bin, error) {
+	return ds.histogram(ctx, votesPerStoryQuery, binWidth)
+}
+
+// VotesPerComment computes the histogram of the number of votes of each
+// comment, with bins of the given width.
+func (ds Datastore) VotesPerComment(ctx context.Context, binWidth int64) ([]config.Bin, error) {
+	return ds.histogram(ctx, votesPerCommentQuery, binWidth)
+}
+
+// CommentsPerStory computes the histogram of the number of comments of each
+// story, with bins of the given width.
+func (ds Datastore) CommentsPerStory(ctx context.Context, binWidth int64) ([]config.Bin, error) {
+	return ds.histogram(ctx, commentsPerStoryQuery, binWidth)
+}
+
+// histogram bins the values returned by countQuery.
+// Bins go from 0 to the largest value, without gaps, so that empty bins are
+// included.
+func (ds Datastore) histogram(ctx context.Context, countQuery string, binWidth int64) ([]config.Bin, error) {
+	rows, err := ds.Db.QueryContext(ctx, "SELECT n DIV ? AS bin, COUNT(*) FROM ("+countQuery+") counts GROUP BY bin ORDER BY bin", binWidth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hist []config.Bin
+	for rows.Next() {
+		var bin, count int64
+		if err := rows.Scan(&bin, &count); err != nil {
+			return nil, err
+		}
+		for int64(len(hist)) < bin {
+			hist = append(hist, config.Bin{Bin: int64(len(hist)) * binWidth})
+		}
+		hist = append(hist, config.Bin{Bin: bin * binWidth, Count: count})
+	}
+
+	return hist, rows.Err()
+}
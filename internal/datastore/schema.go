@@ -0,0 +1,75 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+)
+
+// tables of the benchmark schema, in creation order
+var tables = []string{"users", "stories", "comments", "votes"}
+
+// schema creates the benchmark tables, along with the indexes needed by the
+// queries that the baseline runs against the datastore.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+		username VARCHAR(50) NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE INDEX users_username (username)
+	) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS stories (
+		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+		user_id INT UNSIGNED NOT NULL,
+		title VARCHAR(150) NOT NULL,
+		description MEDIUMTEXT,
+		short_id VARCHAR(6) NOT NULL,
+		vote_sum INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (id),
+		UNIQUE INDEX stories_short_id (short_id),
+		INDEX stories_user_id (user_id),
+		INDEX stories_vote_sum (vote_sum)
+	) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+		user_id INT UNSIGNED NOT NULL,
+		story_id INT UNSIGNED NOT NULL,
+		comment MEDIUMTEXT NOT NULL,
+		vote_sum INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (id),
+		INDEX comments_story_id (story_id),
+		INDEX comments_user_id (user_id)
+	) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4`,
+	`CREATE TABLE IF NOT EXISTS votes (
+		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+		user_id INT UNSIGNED NOT NULL,
+		story_id INT UNSIGNED,
+		comment_id INT UNSIGNED,
+		vote TINYINT NOT NULL,
+		PRIMARY KEY (id),
+		INDEX votes_user_story_id (user_id, story_id),
+		INDEX votes_user_comment_id (user_id, comment_id),
+		INDEX votes_story_id (story_id),
+		INDEX votes_comment_id (comment_id)
+	) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4`,
+}
+
+// CreateSchema creates the benchmark tables that do not exist yet.
+func (ds Datastore) CreateSchema(ctx context.Context) error {
+	for _, stmt := range schema {
+		if _, err := ds.Db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset empties the benchmark tables, and resets their AUTO_INCREMENT
+// counters, so that preloaded IDs start from 1 again.
+func (ds Datastore) Reset(ctx context.Context) error {
+	for _, table := range tables {
+		if _, err := ds.Db.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE %s", table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
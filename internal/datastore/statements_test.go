@@ -0,0 +1,213 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver is a database/sql driver that records the statements it is
+// sent, and whether they were prepared.
+type fakeDriver struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (d *fakeDriver) record(call string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, call)
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) { return fakeConn{d}, nil }
+
+func (d *fakeDriver) Driver() driver.Driver { return d }
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.record("prepare " + query)
+	return fakeStmt{c.d, query}, nil
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.d.record("begin")
+	return fakeTx{c.d}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.record("exec " + query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.d.record("query " + query)
+	return fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.record("stmt exec " + s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.d.record("stmt query " + s.query)
+	return fakeRows{}, nil
+}
+
+type fakeTx struct {
+	d *fakeDriver
+}
+
+func (t fakeTx) Commit() error {
+	t.d.record("commit")
+	return nil
+}
+
+func (t fakeTx) Rollback() error {
+	t.d.record("rollback")
+	return nil
+}
+
+// fakeRows is an empty result set.
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"vote"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func TestStatementModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		prepared bool
+		want     []string
+	}{
+		{
+			mode:     "prepared",
+			prepared: true,
+			want: []string{
+				"stmt exec " + insertUser,
+				"stmt exec " + insertStory,
+				"stmt exec " + insertComment,
+				"stmt exec " + insertStoryVote,
+				"begin",
+				"stmt exec " + insertCommentVote,
+				"stmt exec " + updateCommentVoteSum,
+				"commit",
+				"begin",
+				"stmt query " + selectUserStoryVote,
+				"stmt exec " + insertStoryVote,
+				"stmt exec " + updateStoryVoteSum,
+				"commit",
+				// only the datastore statements are prepared
+				"exec UPDATE stories SET title = ?",
+			},
+		},
+		{
+			mode:     "interpolated",
+			prepared: false,
+			want: []string{
+				"exec " + insertUser,
+				"exec " + insertStory,
+				"exec " + insertComment,
+				"exec " + insertStoryVote,
+				"begin",
+				"exec " + insertCommentVote,
+				"exec " + updateCommentVoteSum,
+				"commit",
+				"begin",
+				"query " + selectUserStoryVote,
+				"exec " + insertStoryVote,
+				"exec " + updateStoryVoteSum,
+				"commit",
+				"exec UPDATE stories SET title = ?",
+			},
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			d := &fakeDriver{}
+			db := sql.OpenDB(d)
+			defer db.Close()
+			// statements are prepared once per connection
+			db.SetMaxOpenConns(1)
+
+			ds, err := newDatastore(db, tc.prepared)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var prepares int
+			for _, call := range d.calls {
+				if strings.HasPrefix(call, "prepare ") {
+					prepares++
+				}
+			}
+			if tc.prepared {
+				assert.Equal(t, 12, prepares)
+				assert.Len(t, ds.stmts, 12)
+			} else {
+				assert.Equal(t, 0, prepares)
+				assert.Empty(t, ds.stmts)
+			}
+			d.calls = nil
+
+			ctx := context.Background()
+			assert.NoError(t, ds.Adduser(ctx, 1, "user1"))
+			assert.NoError(t, ds.Submit(ctx, 1, 1, "title", "description", "000001"))
+			assert.NoError(t, ds.Comment(ctx, 1, 1, "comment"))
+			assert.NoError(t, ds.StoryVoteSimple(ctx, 1, 1, 1))
+			assert.NoError(t, ds.CommentVoteUpdateCount(ctx, 1, 1, 1))
+			ds.UniqueVotes = true
+			assert.NoError(t, ds.StoryVoteUpdateCount(ctx, 2, 1, 1))
+			assert.NoError(t, ds.exec(ctx, "UPDATE stories SET title = ?", "title"))
+
+			assert.Equal(t, tc.want, d.calls)
+		})
+	}
+}
+
+func TestStatementModeDataSourceName(t *testing.T) {
+	for _, tc := range []struct {
+		prepared          bool
+		interpolateParams bool
+	}{
+		{prepared: true, interpolateParams: false},
+		{prepared: false, interpolateParams: true},
+	} {
+		cfg, err := mysql.ParseDSN(dataSourceName("db:3306", "lobsters", "user", "password", tc.prepared))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.interpolateParams, cfg.InterpolateParams)
+		assert.Equal(t, "db:3306", cfg.Addr)
+		assert.Equal(t, "lobsters", cfg.DBName)
+		assert.Equal(t, "user", cfg.User)
+		assert.Equal(t, "password", cfg.Passwd)
+	}
+}
+
+func TestUnknownStatementMode(t *testing.T) {
+	_, err := NewDatastore("db:3306", "lobsters", "user", "password", "cached")
+	assert.Error(t, err)
+}
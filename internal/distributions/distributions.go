@@ -1,9 +1,10 @@
 package distributions
 
 import (
+	"math"
 	"math/rand"
-	"time"
 
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
 	"github.com/google/btree"
 )
 
@@ -20,19 +21,19 @@ type treeNode struct {
 	Start  int64
 	NextID int64
 	Count  int64
+	// the weight of each ID of the bin, 0 for NewSampler
+	Weight int64
 }
 
+// averageScale is the precision of bin averages in NewAverageSampler.
+const averageScale = 1000
+
 func (n treeNode) Less(than btree.Item) bool {
 	return n.Start < than.(treeNode).Start
 }
 
 // NewSampler ...
-func NewSampler(inDistribution []struct {
-	Bin   int64
-	Count int64
-}) Sampler {
-	rand.Seed(time.Now().UTC().UnixNano())
-
+func NewSampler(inDistribution []config.Bin) Sampler {
 	s := Sampler{
 		bins: btree.New(2),
 	}
@@ -64,17 +65,65 @@ func NewSampler(inDistribution []struct {
 	return s
 }
 
-// Sample ...
-func (s Sampler) Sample() int64 {
+// NewAverageSampler creates a sampler that weighs each ID by the average
+// value of its bin, so that IDs get sampled as many times as the histogram
+// says on average.
+// averages[i] is the average of the i-th bin; bins without one use the middle
+// of the bin.
+func NewAverageSampler(inDistribution []config.Bin, averages []float64) Sampler {
+	s := Sampler{
+		bins: btree.New(2),
+	}
+
+	start := int64(0)
+	nextID := int64(0)
+
+	for i, d := range inDistribution {
+		avg := float64(d.Bin) + float64(binWidth(inDistribution)-1)/2
+		if i < len(averages) {
+			avg = averages[i]
+		}
+		weight := int64(math.Round(avg * averageScale))
+		if weight < 1 {
+			weight = 1
+		}
+
+		s.bins.ReplaceOrInsert(treeNode{
+			Start:  start,
+			NextID: nextID,
+			Count:  d.Count,
+			Weight: weight,
+		})
+
+		start += d.Count * weight
+		nextID += d.Count
+	}
+
+	s.nextID = nextID
+	s.end = start
+
+	return s
+}
+
+// Sample draws an ID using the given random source.
+func (s Sampler) Sample(r *rand.Rand) int64 {
 	var bin treeNode
 	it := func(node btree.Item) bool {
 		bin = node.(treeNode)
 		return false
 	}
 
-	sample := rand.Int63n(s.end)
+	sample := r.Int63n(s.end)
 
 	s.bins.DescendLessOrEqual(treeNode{Start: sample}, it)
 
+	if bin.Weight > 0 {
+		return bin.NextID + (sample-bin.Start)/bin.Weight
+	}
 	return bin.NextID + (sample % bin.Count)
 }
+
+// IDs returns the number of IDs the sampler picks from (0 to IDs()-1).
+func (s Sampler) IDs() int64 {
+	return s.nextID
+}
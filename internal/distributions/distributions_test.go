@@ -2,10 +2,13 @@ package distributions
 
 import (
 	"math"
+	"math/rand"
 	"os"
 	"sort"
 	"testing"
+	"time"
 
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,65 +17,35 @@ func TestMain(m *testing.M) {
 	os.Exit(returnCode)
 }
 
-var tests = [][]struct {
-	Bin   int64
-	Count int64
-}{
-	[]struct {
-		Bin   int64
-		Count int64
-	}{
-		struct {
-			Bin   int64
-			Count int64
-		}{
+var tests = [][]config.Bin{
+	[]config.Bin{
+		config.Bin{
 			Bin:   0,
 			Count: 4000,
 		},
-		struct {
-			Bin   int64
-			Count int64
-		}{
+		config.Bin{
 			Bin:   10,
 			Count: 500,
 		},
-		struct {
-			Bin   int64
-			Count int64
-		}{
+		config.Bin{
 			Bin:   20,
 			Count: 200,
 		},
-		struct {
-			Bin   int64
-			Count int64
-		}{
+		config.Bin{
 			Bin:   30,
 			Count: 1000,
 		},
 	},
-	[]struct {
-		Bin   int64
-		Count int64
-	}{
-		struct {
-			Bin   int64
-			Count int64
-		}{
+	[]config.Bin{
+		config.Bin{
 			Bin:   0,
 			Count: 995,
 		},
-		struct {
-			Bin   int64
-			Count int64
-		}{
+		config.Bin{
 			Bin:   10,
 			Count: 0,
 		},
-		struct {
-			Bin   int64
-			Count int64
-		}{
+		config.Bin{
 			Bin:   500,
 			Count: 5,
 		},
@@ -110,16 +83,14 @@ func TestSample(t *testing.T) {
 		// keeping track of the number of votes of each element
 		votes := make(map[int64]int64, 0)
 		sampler := NewSampler(histVotes)
+		r := rand.New(rand.NewSource(42))
 		for i := int64(0); i < histNVotes; i++ {
-			votes[sampler.Sample()]++
+			votes[sampler.Sample(r)]++
 		}
 
 		// create a new histogram by copying the bins from the input histogram
 		// (but leaving counts to 0)
-		sampleVotes := make([]struct {
-			Bin   int64
-			Count int64
-		}, len(histVotes))
+		sampleVotes := make([]config.Bin, len(histVotes))
 		for i := range histVotes {
 			sampleVotes[i].Bin = histVotes[i].Bin
 		}
@@ -174,3 +145,152 @@ func TestSample(t *testing.T) {
 		}
 	}
 }
+
+func TestPerID(t *testing.T) {
+	for _, hist := range tests {
+		for _, n := range []int64{1000, 4321, 10000} {
+			counts := ScaleCounts(hist, n)
+
+			var total int64
+			for _, c := range counts {
+				total += c
+			}
+			assert.Equal(t, n, total)
+
+			// the generated values reproduce the scaled histogram exactly
+			values := PerID(hist, n)
+			assert.Equal(t, int(n)+1, len(values))
+			assert.Equal(t, counts, Bins(hist, values))
+		}
+	}
+}
+
+func TestKeyDistributions(t *testing.T) {
+	const n = 1000
+	const samples = 100000
+	r := rand.New(rand.NewSource(42))
+
+	zipf, err := NewZipf(n, 0.99)
+	assert.Nil(t, err)
+	hotspot, err := NewHotspot(n, 0.2, 0.8)
+	assert.Nil(t, err)
+	newest := int64(2 * n)
+	latest, err := NewLatest(n, 0.99)
+	assert.Nil(t, err)
+	latest.Add(newest, 0)
+	latest.Add(newest-1, 0)
+
+	var zipfCounts [n + 1]int64
+	var hot, recent int64
+	for i := 0; i < samples; i++ {
+		key := zipf.Sample(r)
+		assert.True(t, key >= 1 && key <= n)
+		zipfCounts[key]++
+
+		key = hotspot.Sample(r)
+		assert.True(t, key >= 1 && key <= n)
+		if key <= n/5 {
+			hot++
+		}
+
+		key = latest.Sample(r)
+		assert.True(t, key > newest-n && key <= newest)
+		if key > newest-10 {
+			recent++
+		}
+	}
+
+	// the first keys are the most popular ones
+	assert.Greater(t, zipfCounts[1], zipfCounts[2])
+	assert.Greater(t, zipfCounts[2], zipfCounts[10])
+	assert.Greater(t, zipfCounts[10], zipfCounts[n])
+
+	// a 1% difference should be ok
+	assert.Greater(t, 0.01, math.Abs(float64(hot)/samples-0.8))
+
+	// the 10 newest keys are 1% of the keys, but get a lot more samples
+	assert.Greater(t, float64(recent)/samples, 0.2)
+
+	_, err = NewZipf(n, 1)
+	assert.NotNil(t, err)
+}
+
+func TestGoodnessOfFit(t *testing.T) {
+	// identical histograms fit perfectly
+	f := compare([]int64{100, 50, 10}, []int64{100, 50, 10})
+	assert.Equal(t, 0.0, f.ChiSquare)
+	assert.Equal(t, 0.0, f.KS)
+	assert.Equal(t, 1.0, f.ChiSquareP)
+
+	// chi-square survival function, for 1 and 4 degrees of freedom
+	assert.InDelta(t, 0.05, gammaQ(0.5, 3.841/2), 0.001)
+	assert.InDelta(t, 0.05, gammaQ(2, 9.488/2), 0.001)
+
+	// very different histograms do not
+	f = compare([]int64{1000, 500, 100}, []int64{100, 500, 1000})
+	assert.Less(t, f.ChiSquareP, 0.001)
+	assert.Less(t, f.KSP, 0.001)
+}
+
+func TestAverageSampler(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for _, hist := range tests {
+		f := CheckSampler(NewAverageSampler(hist, nil), hist, Total(hist, nil), r)
+
+		// sampled counts per ID spread around the bin averages, so the
+		// histograms do not match exactly
+		assert.Less(t, f.KS, 0.05)
+		assert.Equal(t, len(hist), len(f.Sampled))
+	}
+}
+
+func TestDynamic(t *testing.T) {
+	const samples = 100000
+	r := rand.New(rand.NewSource(42))
+
+	d := NewDynamic(NewUniform(1000), 1000, 1000, time.Minute)
+
+	fraction := func(key int64, at time.Duration) float64 {
+		var n int
+		for i := 0; i < samples; i++ {
+			if d.SampleAt(r, at) == key {
+				n++
+			}
+		}
+		return float64(n) / samples
+	}
+
+	// the new key weighs as much as all the preloaded ones
+	d.Add(1001, time.Hour)
+	assert.InDelta(t, 0.5, fraction(1001, time.Hour), 0.01)
+
+	// and a quarter of its initial weight two half lives later
+	assert.InDelta(t, 0.2, fraction(1001, time.Hour+2*time.Minute), 0.01)
+
+	// a key added one half life later weighs twice as much
+	d.Add(1002, time.Hour+time.Minute)
+	at := time.Hour + 2*time.Minute
+	assert.InDelta(t, 1.0/7, fraction(1001, at), 0.01)
+	assert.InDelta(t, 2.0/7, fraction(1002, at), 0.01)
+
+	// keys fade away, and are forgotten as new ones are added
+	assert.Less(t, fraction(1001, 2*time.Hour), 0.001)
+	d.Add(1003, 2*time.Hour)
+	assert.Equal(t, []int64{1003}, d.keys[d.start:])
+	assert.Equal(t, 0.0, fraction(1001, 2*time.Hour))
+	assert.InDelta(t, 0.5, fraction(1003, 2*time.Hour), 0.01)
+}
+
+func BenchmarkDynamicSample(b *testing.B) {
+	d := NewDynamic(NewUniform(1000), 1000, 10, time.Minute)
+	for i := 0; i < 100000; i++ {
+		d.Add(int64(1000+i), time.Duration(i)*time.Millisecond)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(42))
+		for pb.Next() {
+			d.SampleAt(r, 100*time.Second)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package distributions
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dynamic extends a distribution of the preloaded keys with keys added while
+// sampling (stories submitted during the benchmark).
+// An added key starts with an initial weight that halves every halfLife, so
+// that new keys get a lot of attention at first, and then fade into the
+// background.
+// Weights are relative to the average preloaded key, which weighs 1.
+// Time is the offset of operations from the start of the run, as scheduled,
+// rather than the wall clock.
+// Keys are added by the caller once their story is inserted, so the keys, and
+// when they are added, depend on the timing and failures of the run: samples
+// are not reproducible with a fixed seed once keys are added.
+//
+// The weight of a key added at a is, at t, initialWeight * 2^((a-t)/halfLife),
+// so keys are kept with their weight relative to the origin, 2^((a-origin)/
+// halfLife), and with the running total of those, which makes a sample
+// O(log n) in the number of added keys.
+type Dynamic struct {
+	base          Distribution
+	baseWeight    float64
+	initialWeight float64
+	halfLife      time.Duration
+
+	mu     sync.RWMutex
+	origin time.Duration
+	keys   []int64
+	added  []time.Duration
+	// cumulative weights relative to the origin, cum[i] is the total of
+	// keys[:i+1]
+	cum []float64
+	// keys[:start] are forgotten
+	start int
+	last  time.Duration
+}
+
+const (
+	// minWeight is the weight, relative to the initial one, below which
+	// added keys are forgotten (after ~10 half lives).
+	minWeight = 1e-3
+	// maxOriginLag is how many half lives the origin may fall behind the
+	// oldest key, before weights relative to it lose precision.
+	maxOriginLag = 32
+)
+
+// NewDynamic ...
+// baseKeys is the number of keys of base.
+func NewDynamic(base Distribution, baseKeys int64, initialWeight float64, halfLife time.Duration) *Dynamic {
+	return &Dynamic{
+		base:          base,
+		baseWeight:    float64(baseKeys),
+		initialWeight: initialWeight,
+		halfLife:      halfLife,
+	}
+}
+
+// Add adds a key, with the initial weight at the given offset, and forgets
+// the keys that no longer weigh anything.
+func (d *Dynamic) Add(key int64, at time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.start == len(d.keys) {
+		d.origin = at
+	}
+	var total float64
+	if len(d.cum) > 0 {
+		total = d.cum[len(d.cum)-1]
+	}
+	d.keys = append(d.keys, key)
+	d.added = append(d.added, at)
+	d.cum = append(d.cum, total+d.decay(d.origin-at))
+	if at > d.last {
+		d.last = at
+	}
+
+	// keys are added in (roughly) time order, so the oldest ones come first
+	for d.start < len(d.keys) && d.decay(at-d.added[d.start]) < minWeight {
+		d.start++
+	}
+	if d.start > len(d.keys)/2 || (d.halfLife > 0 && d.start < len(d.keys) && d.added[d.start]-d.origin > maxOriginLag*d.halfLife) {
+		d.compact()
+	}
+}
+
+// compact drops the forgotten keys, and moves the origin to the oldest key.
+func (d *Dynamic) compact() {
+	keys := append([]int64(nil), d.keys[d.start:]...)
+	added := append([]time.Duration(nil), d.added[d.start:]...)
+	d.keys, d.added, d.cum, d.start = keys, added, make([]float64, len(keys)), 0
+	if len(keys) == 0 {
+		return
+	}
+
+	d.origin = added[0]
+	var total float64
+	for i, at := range added {
+		total += d.decay(d.origin - at)
+		d.cum[i] = total
+	}
+}
+
+// Sample draws a key using the given random source, with the weights at the
+// offset of the most recently added key.
+func (d *Dynamic) Sample(r *rand.Rand) int64 {
+	d.mu.RLock()
+	at := d.last
+	d.mu.RUnlock()
+	return d.SampleAt(r, at)
+}
+
+// SampleAt draws a key using the given random source, with the weights at
+// the given offset.
+func (d *Dynamic) SampleAt(r *rand.Rand, at time.Duration) int64 {
+	d.mu.RLock()
+	if d.start == len(d.keys) {
+		d.mu.RUnlock()
+		return d.base.Sample(r)
+	}
+
+	var forgotten float64
+	if d.start > 0 {
+		forgotten = d.cum[d.start-1]
+	}
+	// from weights relative to the origin to weights at the given offset
+	scale := d.initialWeight * d.decay(at-d.origin)
+	total := (d.cum[len(d.cum)-1] - forgotten) * scale
+
+	u := r.Float64() * (total + d.baseWeight)
+	if u < total {
+		target := forgotten + u/scale
+		i := d.start + sort.SearchFloat64s(d.cum[d.start:], target)
+		if i == len(d.keys) {
+			i--
+		}
+		key := d.keys[i]
+		d.mu.RUnlock()
+		return key
+	}
+	d.mu.RUnlock()
+
+	return d.base.Sample(r)
+}
+
+// decay returns the fraction of its initial weight that a key of the given
+// age keeps.
+func (d *Dynamic) decay(age time.Duration) float64 {
+	if d.halfLife <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(d.halfLife))
+}
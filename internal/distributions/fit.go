@@ -0,0 +1,191 @@
+package distributions
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+)
+
+// NewSamplerMode creates a sampler in the given mode: "fixed" (the default)
+// for NewSampler, or "average" for NewAverageSampler.
+func NewSamplerMode(mode string, inDistribution []config.Bin, averages []float64) (Sampler, error) {
+	switch mode {
+	case "", "fixed":
+		return NewSampler(inDistribution), nil
+	case "average":
+		return NewAverageSampler(inDistribution, averages), nil
+	default:
+		return Sampler{}, errors.New("unknown sampler mode")
+	}
+}
+
+// Total estimates the sum of the values of a histogram (the number of votes
+// of all stories, ..), from the average of each bin: averages[i] if given,
+// the middle of the bin otherwise.
+func Total(hist []config.Bin, averages []float64) int64 {
+	var total float64
+	for i, d := range hist {
+		avg := float64(d.Bin) + float64(binWidth(hist)-1)/2
+		if i < len(averages) {
+			avg = averages[i]
+		}
+		total += avg * float64(d.Count)
+	}
+	return int64(math.Round(total))
+}
+
+// Fit is the outcome of a goodness-of-fit test of a sampler against its
+// histogram.
+type Fit struct {
+	// number of IDs in each bin, in the histogram and according to the
+	// samples
+	Target  []int64
+	Sampled []int64
+	// Pearson's chi-square test, over the bins with a non-zero target
+	ChiSquare        float64
+	DegreesOfFreedom int
+	ChiSquareP       float64
+	// Kolmogorov-Smirnov test: the largest distance between the cumulative
+	// distributions of the bins
+	KS  float64
+	KSP float64
+}
+
+// CheckSampler draws the given number of samples from s, counts how many
+// times each ID was sampled, and compares the histogram of these counts with
+// hist.
+func CheckSampler(s Sampler, hist []config.Bin, samples int64, r *rand.Rand) Fit {
+	// indexed by ID+1, see Bins
+	values := make([]int64, s.IDs()+1)
+	for i := int64(0); i < samples; i++ {
+		values[s.Sample(r)+1]++
+	}
+
+	target := make([]int64, len(hist))
+	for i, d := range hist {
+		target[i] = d.Count
+	}
+
+	return compare(target, Bins(hist, values))
+}
+
+func compare(target, sampled []int64) Fit {
+	f := Fit{
+		Target:  target,
+		Sampled: sampled,
+	}
+
+	var targetTotal, sampledTotal float64
+	for i := range target {
+		targetTotal += float64(target[i])
+		sampledTotal += float64(sampled[i])
+	}
+	if targetTotal == 0 || sampledTotal == 0 {
+		return f
+	}
+
+	bins := 0
+	var targetCDF, sampledCDF float64
+	for i := range target {
+		if target[i] > 0 {
+			expected := float64(target[i]) * sampledTotal / targetTotal
+			diff := float64(sampled[i]) - expected
+			f.ChiSquare += diff * diff / expected
+			bins++
+		}
+
+		targetCDF += float64(target[i]) / targetTotal
+		sampledCDF += float64(sampled[i]) / sampledTotal
+		if d := math.Abs(targetCDF - sampledCDF); d > f.KS {
+			f.KS = d
+		}
+	}
+
+	f.DegreesOfFreedom = bins - 1
+	f.ChiSquareP = 1
+	if f.DegreesOfFreedom > 0 {
+		f.ChiSquareP = gammaQ(float64(f.DegreesOfFreedom)/2, f.ChiSquare/2)
+	}
+	f.KSP = kolmogorovQ(math.Sqrt(sampledTotal) * f.KS)
+
+	return f
+}
+
+// gammaQ is the regularized upper incomplete gamma function Q(a, x), the
+// chi-square survival function for a = dof/2 and x = chi-square/2.
+// Numerical Recipes, 6.2.
+func gammaQ(a, x float64) float64 {
+	const eps = 1e-12
+	const fpmin = 1e-300
+
+	if x <= 0 {
+		return 1
+	}
+	lg, _ := math.Lgamma(a)
+	norm := math.Exp(-x + a*math.Log(x) - lg)
+
+	if x < a+1 {
+		// series of P(a, x)
+		ap := a
+		del := 1 / a
+		sum := del
+		for n := 0; n < 1000; n++ {
+			ap++
+			del *= x / ap
+			sum += del
+			if math.Abs(del) < math.Abs(sum)*eps {
+				break
+			}
+		}
+		return 1 - sum*norm
+	}
+
+	// continued fraction of Q(a, x), with Lentz's method
+	b := x + 1 - a
+	c := 1 / fpmin
+	d := 1 / b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = b + an/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h * norm
+}
+
+// kolmogorovQ is the survival function of the Kolmogorov distribution,
+// the Kolmogorov-Smirnov p-value for lambda = sqrt(n) * D.
+// Numerical Recipes, 14.3.
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 0.2 {
+		return 1
+	}
+
+	var sum float64
+	sign := 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * 2 * math.Exp(-2*float64(k*k)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+
+	return math.Max(0, math.Min(1, sum))
+}
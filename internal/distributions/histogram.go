@@ -0,0 +1,96 @@
+package distributions
+
+import (
+	"sort"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+)
+
+// A histogram is a list of bins, in increasing order: Count elements (stories,
+// comments, ..) have a value (votes, comments, ..) between Bin and the next
+// bin's Bin.
+
+// ScaleCounts scales the bin counts of hist so that they add up to n.
+// Rounding errors are given to the bins with the largest remainders.
+func ScaleCounts(hist []config.Bin, n int64) []int64 {
+	counts := make([]int64, len(hist))
+
+	var total int64
+	for _, d := range hist {
+		total += d.Count
+	}
+	if total == 0 {
+		return counts
+	}
+
+	remainders := make([]int, len(hist))
+	var assigned int64
+	for i, d := range hist {
+		counts[i] = d.Count * n / total
+		assigned += counts[i]
+		remainders[i] = i
+	}
+	sort.SliceStable(remainders, func(i, j int) bool {
+		return hist[remainders[i]].Count*n%total > hist[remainders[j]].Count*n%total
+	})
+	for i := int64(0); i < n-assigned; i++ {
+		counts[remainders[i]]++
+	}
+
+	return counts
+}
+
+// PerID assigns a value to each of the IDs 1 to n, so that the histogram of
+// the values reproduces hist, scaled to n elements.
+// IDs are assigned to bins in order, like the Sampler does, and the values
+// of a bin are spread evenly over its width.
+// The returned slice is indexed by ID.
+func PerID(hist []config.Bin, n int64) []int64 {
+	values := make([]int64, n+1)
+
+	id := 1
+	width := binWidth(hist)
+	for i, count := range ScaleCounts(hist, n) {
+		for k := int64(0); k < count; k++ {
+			values[id] = hist[i].Bin + k*width/count
+			id++
+		}
+	}
+
+	return values
+}
+
+// Bins counts the values (indexed by ID, from 1) that fall in each bin of
+// hist.
+// Values below the first bin are counted in the first bin.
+func Bins(hist []config.Bin, values []int64) []int64 {
+	counts := make([]int64, len(hist))
+	if len(hist) == 0 {
+		return counts
+	}
+
+	for _, v := range values[1:] {
+		i := sort.Search(len(hist), func(i int) bool { return hist[i].Bin > v }) - 1
+		if i < 0 {
+			i = 0
+		}
+		counts[i]++
+	}
+
+	return counts
+}
+
+// binWidth returns the width of the bins of hist: the smallest distance
+// between consecutive bins, as empty bins may be left out.
+func binWidth(hist []config.Bin) int64 {
+	var width int64
+	for j := 1; j < len(hist); j++ {
+		if d := hist[j].Bin - hist[j-1].Bin; d > 0 && (width == 0 || d < width) {
+			width = d
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	return width
+}
@@ -0,0 +1,178 @@
+package distributions
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Distribution picks keys (story IDs, comment IDs, ..).
+// Keys start from 1, except for the Sampler, which also picks 0.
+type Distribution interface {
+	Sample(r *rand.Rand) int64
+}
+
+// Growing is a distribution that keys are added to while sampling, by
+// operations scheduled at the given offset.
+type Growing interface {
+	Distribution
+	Add(key int64, at time.Duration)
+}
+
+// Uniform picks each of the keys 1 to n with the same probability.
+type Uniform struct {
+	n int64
+}
+
+// NewUniform ...
+func NewUniform(n int64) Uniform {
+	return Uniform{n: n}
+}
+
+// Sample draws a key using the given random source.
+func (d Uniform) Sample(r *rand.Rand) int64 {
+	return r.Int63n(d.n) + 1
+}
+
+// Zipf picks the keys 1 to n following a Zipfian distribution: key i is
+// picked with a probability proportional to 1/i^theta, so key 1 is the most
+// popular one.
+// Port of YCSB's ZipfianGenerator (Gray et al., Quickly Generating
+// Billion-Record Synthetic Databases, SIGMOD 1994), which supports
+// 0 < theta < 1.
+type Zipf struct {
+	n     int64
+	theta float64
+	alpha float64
+	zetan float64
+	eta   float64
+}
+
+// NewZipf ...
+func NewZipf(n int64, theta float64) (Zipf, error) {
+	if theta <= 0 || theta >= 1 {
+		return Zipf{}, errors.New("zipf theta needs to be between 0 and 1")
+	}
+	if n < 1 {
+		return Zipf{}, errors.New("zipf needs at least one key")
+	}
+
+	zetan := zeta(n, theta)
+	zeta2 := zeta(2, theta)
+
+	return Zipf{
+		n:     n,
+		theta: theta,
+		alpha: 1 / (1 - theta),
+		zetan: zetan,
+		eta:   (1 - math.Pow(2/float64(n), 1-theta)) / (1 - zeta2/zetan),
+	}, nil
+}
+
+// Sample draws a key using the given random source.
+func (d Zipf) Sample(r *rand.Rand) int64 {
+	u := r.Float64()
+	uz := u * d.zetan
+
+	if uz < 1 {
+		return 1
+	}
+	if uz < 1+math.Pow(0.5, d.theta) {
+		return 2
+	}
+
+	key := 1 + int64(float64(d.n)*math.Pow(d.eta*u-d.eta+1, d.alpha))
+	if key > d.n {
+		key = d.n
+	}
+	return key
+}
+
+func zeta(n int64, theta float64) float64 {
+	var sum float64
+	for i := int64(1); i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+// Hotspot picks a fraction of the keys (the hot set: keys 1 to
+// keyFraction*n) for a fraction opFraction of the samples.
+// Keys are picked uniformly within and outside the hot set.
+type Hotspot struct {
+	n          int64
+	hot        int64
+	opFraction float64
+}
+
+// NewHotspot ...
+func NewHotspot(n int64, keyFraction, opFraction float64) (Hotspot, error) {
+	if keyFraction <= 0 || keyFraction > 1 || opFraction < 0 || opFraction > 1 {
+		return Hotspot{}, errors.New("hotspot fractions need to be between 0 and 1")
+	}
+
+	hot := int64(float64(n) * keyFraction)
+	if hot < 1 {
+		hot = 1
+	}
+
+	return Hotspot{
+		n:          n,
+		hot:        hot,
+		opFraction: opFraction,
+	}, nil
+}
+
+// Sample draws a key using the given random source.
+func (d Hotspot) Sample(r *rand.Rand) int64 {
+	if d.hot >= d.n || r.Float64() < d.opFraction {
+		return r.Int63n(d.hot) + 1
+	}
+	return d.hot + r.Int63n(d.n-d.hot) + 1
+}
+
+// Latest favors the most recent keys: the distance from the newest key
+// follows a Zipfian distribution over n keys.
+// The newest key is n, until newer keys are added.
+// Stories are added once they are inserted, so the newest key at a given
+// point of the op sequence depends on the timing of the run, and samples are
+// not reproducible with a fixed seed once stories are submitted.
+type Latest struct {
+	zipf   Zipf
+	newest *int64
+}
+
+// NewLatest ...
+func NewLatest(n int64, theta float64) (Latest, error) {
+	zipf, err := NewZipf(n, theta)
+	if err != nil {
+		return Latest{}, err
+	}
+
+	return Latest{
+		zipf:   zipf,
+		newest: &n,
+	}, nil
+}
+
+// Add makes key the newest key, unless a newer one was added.
+// Recency is by key, so at is ignored.
+func (d Latest) Add(key int64, at time.Duration) {
+	for {
+		newest := atomic.LoadInt64(d.newest)
+		if key <= newest || atomic.CompareAndSwapInt64(d.newest, newest, key) {
+			return
+		}
+	}
+}
+
+// Sample draws a key using the given random source.
+func (d Latest) Sample(r *rand.Rand) int64 {
+	key := atomic.LoadInt64(d.newest) - d.zipf.Sample(r) + 1
+	if key < 1 {
+		key = 1
+	}
+	return key
+}
@@ -0,0 +1,71 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// FitHistograms computes the VotesPerStory, VotesPerComment and
+// CommentsPerStory histograms from the configured database, with bins of the
+// given width, and writes them to w as TOML config sections.
+// If dumpFile is set, the SQL dump is first loaded into the database.
+func FitHistograms(configFile, dumpFile string, binWidth int64, w io.Writer) error {
+	if binWidth <= 0 {
+		return errors.New("bin width needs to be positive")
+	}
+
+	ds, err := openDatastore(configFile)
+	if err != nil {
+		return err
+	}
+	defer ds.Db.Close()
+
+	ctx := context.Background()
+
+	if dumpFile != "" {
+		f, err := os.Open(dumpFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log.WithFields(log.Fields{"dump": dumpFile}).Info("loading")
+		if err := ds.LoadDump(ctx, f); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, "[Distributions]"); err != nil {
+		return err
+	}
+	for _, h := range []struct {
+		name string
+		fit  func(context.Context, int64) ([]config.Bin, error)
+	}{
+		{"VotesPerStory", ds.VotesPerStory},
+		{"VotesPerComment", ds.VotesPerComment},
+		{"CommentsPerStory", ds.CommentsPerStory},
+	} {
+		log.WithFields(log.Fields{"histogram": h.name}).Info("fitting")
+		hist, err := h.fit(ctx, binWidth)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range hist {
+			if _, err := fmt.Fprintf(w, "[[Distributions.%s]]\nbin = %d\ncount = %d\n", h.name, d.Bin, d.Count); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+)
+
+// closedLoopClient simulates a fixed number of users.
+// Each user issues its next operation as soon as the previous one completes,
+// after an optional think time.
+func (g *Generator) closedLoopClient(clientID int, r *rand.Rand) measurements.ClientMeasurements {
+	// the first Users % ThreadCount clients run one more user
+	users := g.config.Benchmark.Users / g.config.Benchmark.ThreadCount
+	if clientID < g.config.Benchmark.Users%g.config.Benchmark.ThreadCount {
+		users++
+	}
+
+	st := time.Now()
+	end := st.Add(time.Duration(g.config.Benchmark.Runtime) * time.Second)
+	warmupEnd := st.Add(time.Duration(g.config.Benchmark.Warmup) * time.Second)
+
+	c := g.newCollector(warmupEnd, end)
+
+	// cancels in-flight operations when the run ends
+	ctx, cancel := context.WithDeadline(context.Background(), end)
+	defer cancel()
+
+	var opCnt, opID int64
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		// users run concurrently, so each one needs its own random source
+		go func(r *rand.Rand) {
+			defer wg.Done()
+			for time.Now().Before(end) {
+				// there is no schedule in a closed loop,
+				// an operation is sent as soon as it is generated
+				sendTs := time.Now()
+				op := g.workload.NextOp(r, clientID, sendTs.Sub(st))
+
+				m := g.doOperation(ctx, op, atomic.AddInt64(&opID, 1))
+				m.ScheduledTs = sendTs
+
+				if sendTs.After(warmupEnd) {
+					atomic.AddInt64(&opCnt, 1)
+				}
+
+				c.measurementsCh <- m
+
+				thinkTime := time.NewTimer(g.thinkTime(r))
+				select {
+				case <-thinkTime.C:
+				case <-ctx.Done():
+					thinkTime.Stop()
+				}
+			}
+		}(rand.New(rand.NewSource(r.Int63())))
+	}
+
+	wg.Wait()
+	close(c.measurementsCh)
+
+	return c.clientMeasurements(end.Sub(warmupEnd), opCnt)
+}
+
+// thinkTime samples the time a user waits before issuing its next operation.
+func (g *Generator) thinkTime(r *rand.Rand) time.Duration {
+	mean := float64(time.Duration(g.config.Benchmark.ThinkTime.Mean) * time.Millisecond)
+
+	switch g.config.Benchmark.ThinkTime.Distribution {
+	case "constant":
+		return time.Duration(mean)
+	case "uniform":
+		return time.Duration(r.Float64() * 2 * mean)
+	case "exponential":
+		return time.Duration(r.ExpFloat64() * mean)
+	default:
+		return 0
+	}
+}
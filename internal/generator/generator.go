@@ -1,6 +1,8 @@
 package generator
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"sync"
 	"time"
@@ -19,20 +21,46 @@ import (
 type Generator struct {
 	config   *config.BenchmarkConfig
 	workload *workload.Workload
+	// the trace client that each client replays, for the "replay" workload
+	// type
+	traceClients []int
 }
 
 // NewGenerator ...
 func NewGenerator(conf *config.BenchmarkConfig) (*Generator, error) {
-	rand.Seed(time.Now().UTC().UnixNano())
+	switch conf.Benchmark.Mode {
+	case "", "open", "closed":
+	default:
+		return nil, errors.New("unknown benchmark mode")
+	}
+
+	// every closed loop client runs at least one user
+	if conf.Benchmark.Mode == "closed" && conf.Benchmark.WorkloadType != "replay" && conf.Benchmark.Users < conf.Benchmark.ThreadCount {
+		return nil, fmt.Errorf("closed loop users (%d) are fewer than threads (%d)", conf.Benchmark.Users, conf.Benchmark.ThreadCount)
+	}
+
+	switch conf.Benchmark.ThinkTime.Distribution {
+	case "", "constant", "uniform", "exponential":
+	default:
+		return nil, errors.New("unknown think time distribution")
+	}
 
 	workload, err := workload.NewWorkload(conf)
 	if err != nil {
 		return nil, err
 	}
 
+	// a trace is replayed by as many clients as recorded it
+	var traceClients []int
+	if conf.Benchmark.WorkloadType == "replay" {
+		traceClients = workload.TraceClients()
+		conf.Benchmark.ThreadCount = len(traceClients)
+	}
+
 	return &Generator{
-		workload: workload,
-		config:   conf,
+		workload:     workload,
+		config:       conf,
+		traceClients: traceClients,
 	}, nil
 }
 
@@ -40,167 +68,245 @@ func calculateOpGenerationRate(targetLoad int64) time.Duration {
 	return time.Duration(1e9/float64(targetLoad)) * time.Nanosecond
 }
 
-// Client ...
-func (g *Generator) Client() measurements.ClientMeasurements {
+// Client runs a benchmark client and returns its measurements.
+// Each client draws its operations from its own random source, seeded with
+// the configured seed and clientID.
+func (g *Generator) Client(clientID int) measurements.ClientMeasurements {
+	if g.config.Benchmark.WorkloadType == "replay" {
+		return g.replayClient(g.traceClients[clientID])
+	}
+
+	r := rand.New(rand.NewSource(g.config.Benchmark.Seed + int64(clientID)))
+	if g.config.Benchmark.Mode == "closed" {
+		return g.closedLoopClient(clientID, r)
+	}
+	return g.openLoopClient(clientID, r)
+}
 
+func (g *Generator) openLoopClient(clientID int, r *rand.Rand) measurements.ClientMeasurements {
+	st := time.Now()
 	// perform a new operation every interArrival
-	interArrival := calculateOpGenerationRate(g.config.Benchmark.TargetLoad)
+	p := newPacer(calculateOpGenerationRate(g.config.Benchmark.TargetLoad), st)
 
-	// each operation is responsible for measuring its latency
-	// measurementsCh is used to gather latency measurements
-	measurementsCh := make(chan measurements.Measurement)
+	return g.openLoop(st, p, func(scheduledTs time.Time) operations.Operation {
+		return g.workload.NextOp(r, clientID, scheduledTs.Sub(st))
+	})
+}
 
-	var opCnt, deadlockAborts, opID int64
-	var op operations.Operation
-	var st, now, next time.Time
+// openLoop sends the operations returned by nextOp at the times scheduled by
+// the pacer, without waiting for previous operations to complete.
+func (g *Generator) openLoop(st time.Time, p *pacer, nextOp func(scheduledTs time.Time) operations.Operation) measurements.ClientMeasurements {
+	var opCnt, opID int64
 
-	st = time.Now()
 	end := st.Add(time.Duration(g.config.Benchmark.Runtime) * time.Second)
 	warmpupEnd := st.Add(time.Duration(g.config.Benchmark.Warmup) * time.Second)
 
 	limitReadCh := make(chan struct{}, g.config.Benchmark.MaxInFlightRead)
 	limitWriteCh := make(chan struct{}, g.config.Benchmark.MaxInFlightWrite)
-	//	var inFlightR, maxInFlightR int64
 	limitThreads := true
 	if g.config.Benchmark.MaxInFlightWrite == 1 && g.config.Benchmark.MaxInFlightRead == 1 {
 		limitThreads = false
 	}
 
-	histograms := make(map[string]*stats.Histogram)
-	histograms["read"] = measurements.NewHistogram()
-	histograms["write"] = measurements.NewHistogram()
+	c := g.newCollector(warmpupEnd, end)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		measurementsConsumer(measurementsCh, histograms, &deadlockAborts, warmpupEnd, end)
-	}()
+	// cancels in-flight operations, and signals the limiters to stop waiting
+	// for them, when the run ends
+	ctx, cancel := context.WithDeadline(context.Background(), end)
+	defer cancel()
+	var inFlight sync.WaitGroup
 
 	warmupShortCirc := true
-	nextOp := true
-	next = time.Now()
 
-	for time.Now().UnixNano() < end.UnixNano() {
-		if warmupShortCirc && time.Now().UnixNano() > warmpupEnd.UnixNano() {
+loop:
+	for {
+		scheduledTs, ok := p.wait(end)
+		if !ok {
+			break
+		}
+
+		if warmupShortCirc && scheduledTs.After(warmpupEnd) {
 			fmt.Println("//////// warmupDone")
 			warmupShortCirc = false
 			st = time.Now()
 			opCnt = 0
+			p.resetLag()
 		}
 
-		now = time.Now()
-		if next.UnixNano() > now.UnixNano() {
-			if now.UnixNano() > end.UnixNano() {
-				break
-			}
-			continue
-		}
-
-		if limitThreads {
-			if nextOp {
-				nextOp = false
-				op = g.workload.NextOp()
-			}
-		} else {
-			op = g.workload.NextOp()
-		}
+		op := nextOp(scheduledTs)
 
+		// If too many operations are in flight, hold this one back until a
+		// slot frees up; the pacer then releases the overdue ones immediately.
 		if limitThreads {
+			limitCh := limitWriteCh
 			switch op.(type) {
-			case operations.Frontpage, operations.Story:
-				//			val := atomic.AddInt64(&inFlightR, 1)
-				//			if val > maxInFlightR {
-				//				maxInFlightR = val
-				//			}
-				select {
-				case limitReadCh <- struct{}{}:
-					nextOp = true
-					opID++
-				default:
-					continue
-				}
-			case operations.StoryVote, operations.CommentVote, operations.Submit, operations.Comment:
-				select {
-				case limitWriteCh <- struct{}{}:
-					nextOp = true
-				default:
-					continue
-				}
+			case operations.Frontpage, operations.Story, operations.Recent, operations.Comments, operations.User:
+				limitCh = limitReadCh
+			}
+			select {
+			case limitCh <- struct{}{}:
+			case <-ctx.Done():
+				break loop
 			}
 		}
+		opID++
 
-		go doOperationAsync(op, measurementsCh, limitReadCh, limitWriteCh, limitThreads, nil, opID)
+		p.sent(scheduledTs)
+		inFlight.Add(1)
+		go func(op operations.Operation, scheduledTs time.Time, opID int64) {
+			defer inFlight.Done()
+			g.doOperationAsync(ctx, op, scheduledTs, c.measurementsCh, limitReadCh, limitWriteCh, limitThreads, nil, opID)
+		}(op, scheduledTs, opID)
 
 		opCnt++
-
-		next = next.Add(interArrival)
 	}
 	en := time.Now()
 	runtime := en.Sub(st)
 
-	wg.Wait()
+	// operations still in flight are cancelled at the end of the run,
+	// once they return no more measurements can be sent
+	inFlight.Wait()
+	close(c.measurementsCh)
+
+	m := c.clientMeasurements(runtime, opCnt)
+	m.ScheduleLag = p.lag
 
-	//	fmt.Println("max in flight: ", maxInFlightR)
+	return m
+}
+
+// collector gathers the measurements of a client's operations.
+// Each operation is responsible for measuring its latency, and reports it
+// through measurementsCh.
+type collector struct {
+	measurementsCh chan measurements.Measurement
+	// one histogram per op kind, created when the first measurement arrives
+	// correctedHistograms measure latency from the time each operation was
+	// scheduled to be sent, to account for coordinated omission
+	histograms          map[string]*stats.Histogram
+	correctedHistograms map[string]*stats.Histogram
+	// failed operations are kept out of the histograms
+	errors map[string]*measurements.OpErrors
+	series *measurements.TimeSeries
+	wg     sync.WaitGroup
+}
+
+func (g *Generator) newCollector(warmupEnd, end time.Time) *collector {
+	seriesInterval := time.Duration(g.config.Benchmark.TimeSeriesInterval) * time.Millisecond
+	if seriesInterval == 0 {
+		seriesInterval = time.Second
+	}
+
+	c := &collector{
+		measurementsCh:      make(chan measurements.Measurement),
+		histograms:          make(map[string]*stats.Histogram),
+		correctedHistograms: make(map[string]*stats.Histogram),
+		errors:              make(map[string]*measurements.OpErrors),
+		series:              measurements.NewTimeSeries(seriesInterval),
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.consume(warmupEnd, end)
+	}()
+
+	return c
+}
+
+// clientMeasurements waits for the consumer to finish, and returns the
+// gathered measurements.
+func (c *collector) clientMeasurements(runtime time.Duration, opsOffered int64) measurements.ClientMeasurements {
+	c.wg.Wait()
 
 	return measurements.ClientMeasurements{
-		Runtime:        runtime,
-		OpsOffered:     opCnt,
-		DeadlockAborts: deadlockAborts,
-		Histograms:     histograms,
+		Runtime:             runtime,
+		OpsOffered:          opsOffered,
+		Histograms:          c.histograms,
+		CorrectedHistograms: c.correctedHistograms,
+		Errors:              c.errors,
+		TimeSeries:          c.series,
 	}
 }
 
-func doOperationAsync(op operations.Operation, measurementsCh chan measurements.Measurement, limitReadCh, limitWriteCh chan struct{}, limitThreads bool, inFlightR *int64, opID int64) {
-	opType, respTime, endTs := op.DoOperation(opID)
+func (g *Generator) doOperationAsync(ctx context.Context, op operations.Operation, scheduledTs time.Time, measurementsCh chan measurements.Measurement, limitReadCh, limitWriteCh chan struct{}, limitThreads bool, inFlightR *int64, opID int64) {
+	m := g.doOperation(ctx, op, opID)
+	m.ScheduledTs = scheduledTs
 
 	if limitThreads {
 		switch op.(type) {
-		case operations.Frontpage, operations.Story:
+		case operations.Frontpage, operations.Story, operations.Recent, operations.Comments, operations.User:
 			<-limitReadCh
 		//	atomic.AddInt64(inFlightR, -1)
-		case operations.StoryVote, operations.CommentVote, operations.Submit, operations.Comment:
+		case operations.StoryVote, operations.CommentVote, operations.Submit, operations.Comment, operations.EditComment, operations.Login, operations.Logout:
 			<-limitWriteCh
 		}
 	}
 
-	measurementsCh <- measurements.Measurement{
-		RespTime: respTime,
-		OpType:   opType,
-		EndTs:    endTs,
+	measurementsCh <- m
+}
+
+// doOperation runs an operation, bounded by the configured per-op timeout.
+func (g *Generator) doOperation(ctx context.Context, op operations.Operation, opID int64) measurements.Measurement {
+	var cancel context.CancelFunc
+	if g.config.Benchmark.OpTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(g.config.Benchmark.OpTimeout)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
+	defer cancel()
+
+	return op.DoOperation(ctx, opID)
 }
 
-func measurementsConsumer(measurementsCh chan measurements.Measurement, histograms map[string]*stats.Histogram, deadlockAborts *int64, warmupEnd, end time.Time) {
-	for i, t := 0, time.NewTimer(2*time.Second); true; i++ {
-		select {
-		case m, isopen := <-measurementsCh:
-			if !isopen {
-				return
-			}
-			if m.OpType == measurements.Deadlock {
-				*deadlockAborts++
+// consume runs until measurementsCh is closed.
+func (c *collector) consume(warmupEnd, end time.Time) {
+	for m := range c.measurementsCh {
+		// operations cancelled at the end of the run are not accounted
+		if !m.EndTs.Before(end) {
+			continue
+		}
+		// the time series also covers the warmup period,
+		// to make warmup convergence visible
+		c.series.Add(m)
+		if m.EndTs.After(warmupEnd) {
+			if m.Error != measurements.NoError {
+				opErrors(c.errors, m.OpKind).Add(m)
 			} else {
-				if m.EndTs.UnixNano() > warmupEnd.UnixNano() && m.EndTs.UnixNano() < end.UnixNano() {
-					if m.OpType == measurements.Write {
-						histograms["write"].Add(m.RespTime.Nanoseconds())
-					} else {
-						histograms["read"].Add(m.RespTime.Nanoseconds())
-					}
-				}
+				opHistogram(c.histograms, m.OpKind).Add(m.RespTime.Nanoseconds())
+				opHistogram(c.correctedHistograms, m.OpKind).Add(m.EndTs.Sub(m.ScheduledTs).Nanoseconds())
 			}
-			t.Reset(2 * time.Second)
-		case <-t.C:
-			return
 		}
 	}
 }
 
+func opHistogram(histograms map[string]*stats.Histogram, opKind measurements.OpKind) *stats.Histogram {
+	hist, ok := histograms[opKind.String()]
+	if !ok {
+		hist = measurements.NewHistogram()
+		histograms[opKind.String()] = hist
+	}
+	return hist
+}
+
+func opErrors(opErrs map[string]*measurements.OpErrors, opKind measurements.OpKind) *measurements.OpErrors {
+	errs, ok := opErrs[opKind.String()]
+	if !ok {
+		errs = measurements.NewOpErrors()
+		opErrs[opKind.String()] = errs
+	}
+	return errs
+}
+
 // Preload ...
 func (g *Generator) Preload() error {
 	return g.workload.Preload()
 }
 
+// Verify ...
+func (g *Generator) Verify() error {
+	return g.workload.Verify()
+}
+
 // Close ...
 func (g *Generator) Close() {
 	g.workload.Close()
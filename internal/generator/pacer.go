@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+)
+
+// pacer schedules the operations of an open-loop client.
+// Rather than spinning on the clock, it sleeps until the next operation is due.
+// If the client falls behind, overdue operations are released back-to-back
+// without sleeping, so that the offered load is kept, and the lag between
+// the scheduled and the actual send time is recorded.
+type pacer struct {
+	start time.Time
+	// returns the offset from start of the next operation,
+	// or false if there are no more operations
+	nextOffset func() (time.Duration, bool)
+	lag        measurements.ScheduleLag
+}
+
+// newPacer schedules an operation every interArrival.
+func newPacer(interArrival time.Duration, start time.Time) *pacer {
+	var next time.Duration
+	return &pacer{
+		start: start,
+		nextOffset: func() (time.Duration, bool) {
+			offset := next
+			next += interArrival
+			return offset, true
+		},
+	}
+}
+
+// newReplayPacer schedules operations at the given offsets from start.
+func newReplayPacer(offsets []time.Duration, start time.Time) *pacer {
+	var i int
+	return &pacer{
+		start: start,
+		nextOffset: func() (time.Duration, bool) {
+			if i == len(offsets) {
+				return 0, false
+			}
+			i++
+			return offsets[i-1], true
+		},
+	}
+}
+
+// wait blocks until the next operation is due, and returns the time it was
+// scheduled for.
+// It returns false if the next operation is scheduled after end.
+func (p *pacer) wait(end time.Time) (time.Time, bool) {
+	offset, ok := p.nextOffset()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	scheduledTs := p.start.Add(offset)
+	if !scheduledTs.Before(end) {
+		return time.Time{}, false
+	}
+
+	if d := time.Until(scheduledTs); d > 0 {
+		time.Sleep(d)
+	}
+
+	return scheduledTs, true
+}
+
+// sent records that the operation scheduled for scheduledTs was just sent.
+func (p *pacer) sent(scheduledTs time.Time) {
+	p.lag.Add(time.Since(scheduledTs))
+}
+
+func (p *pacer) resetLag() {
+	p.lag = measurements.ScheduleLag{}
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacerSchedule(t *testing.T) {
+	start := time.Now()
+	p := newPacer(10*time.Millisecond, start)
+	end := start.Add(50 * time.Millisecond)
+
+	var scheduled []time.Time
+	for {
+		scheduledTs, ok := p.wait(end)
+		if !ok {
+			break
+		}
+		// operations are not released before they are due
+		assert.False(t, time.Now().Before(scheduledTs))
+		scheduled = append(scheduled, scheduledTs)
+	}
+
+	assert.Len(t, scheduled, 5)
+	for i, scheduledTs := range scheduled {
+		assert.Equal(t, start.Add(time.Duration(i)*10*time.Millisecond), scheduledTs)
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+}
+
+func TestPacerBehind(t *testing.T) {
+	// a client that starts an hour late releases the overdue operations
+	// back-to-back, at their scheduled times
+	start := time.Now().Add(-time.Hour)
+	p := newPacer(time.Second, start)
+
+	before := time.Now()
+	for i := 0; i < 100; i++ {
+		scheduledTs, ok := p.wait(start.Add(2 * time.Hour))
+		assert.True(t, ok)
+		assert.Equal(t, start.Add(time.Duration(i)*time.Second), scheduledTs)
+		p.sent(scheduledTs)
+	}
+	assert.True(t, time.Since(before) < time.Second)
+
+	assert.Equal(t, int64(100), p.lag.Count)
+	assert.True(t, p.lag.Max >= time.Hour)
+	assert.True(t, p.lag.Mean() > time.Hour-time.Minute)
+
+	p.resetLag()
+	assert.Equal(t, int64(0), p.lag.Count)
+	assert.Equal(t, time.Duration(0), p.lag.Max)
+}
+
+func TestReplayPacer(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+	offsets := []time.Duration{0, time.Millisecond, time.Millisecond, 5 * time.Millisecond}
+
+	for _, tc := range []struct {
+		name string
+		end  time.Time
+		want int
+	}{
+		{"whole trace", start.Add(time.Hour), len(offsets)},
+		{"until end", start.Add(5 * time.Millisecond), 3},
+		{"nothing", start, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newReplayPacer(offsets, start)
+
+			var got int
+			for {
+				scheduledTs, ok := p.wait(tc.end)
+				if !ok {
+					break
+				}
+				assert.Equal(t, start.Add(offsets[got]), scheduledTs)
+				got++
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+)
+
+// replayClient sends the operations the client with the same ID issued in
+// the recorded trace, with their original (optionally scaled) timing.
+func (g *Generator) replayClient(clientID int) measurements.ClientMeasurements {
+	trace := g.workload.Trace(clientID)
+
+	timeScale := g.config.Benchmark.Trace.TimeScale
+	if timeScale == 0 {
+		timeScale = 1
+	}
+	offsets := make([]time.Duration, len(trace))
+	for i, rec := range trace {
+		offsets[i] = time.Duration(float64(rec.Offset) * timeScale)
+	}
+
+	st := time.Now()
+	p := newReplayPacer(offsets, st)
+
+	var i int
+	return g.openLoop(st, p, func(time.Time) operations.Operation {
+		op := g.workload.TraceOp(trace[i])
+		i++
+		return op
+	})
+}
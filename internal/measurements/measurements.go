@@ -2,7 +2,7 @@ package measurements
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"sync"
 	"time"
 
@@ -17,10 +17,80 @@ type Measurements struct {
 
 // ClientMeasurements ...
 type ClientMeasurements struct {
-	Runtime        time.Duration
-	OpsOffered     int64
-	DeadlockAborts int64
-	Histograms     map[string]*stats.Histogram
+	Runtime    time.Duration
+	OpsOffered int64
+	// Histograms only include successful operations
+	Histograms map[string]*stats.Histogram
+	// CorrectedHistograms measure latency from the scheduled send time
+	CorrectedHistograms map[string]*stats.Histogram
+	// failed operations, per op kind
+	Errors      map[string]*OpErrors
+	TimeSeries  *TimeSeries
+	ScheduleLag ScheduleLag
+}
+
+// OpErrors tracks the failed operations of an op kind.
+type OpErrors struct {
+	// error kind -> count
+	Counts map[string]int64
+	// time it took for the operations to fail
+	Histogram *stats.Histogram
+}
+
+// NewOpErrors ...
+func NewOpErrors() *OpErrors {
+	return &OpErrors{
+		Counts:    make(map[string]int64),
+		Histogram: NewHistogram(),
+	}
+}
+
+// Add ...
+func (e *OpErrors) Add(m Measurement) {
+	e.Counts[m.Error.String()]++
+	e.Histogram.Add(m.RespTime.Nanoseconds())
+}
+
+// Merge ...
+func (e *OpErrors) Merge(other *OpErrors) {
+	for errKind, count := range other.Counts {
+		e.Counts[errKind] += count
+	}
+	e.Histogram.Merge(other.Histogram)
+}
+
+// ScheduleLag tracks how late, compared to their scheduled send time,
+// operations were sent by an open-loop client.
+type ScheduleLag struct {
+	Count int64
+	Sum   time.Duration
+	Max   time.Duration
+}
+
+// Add ...
+func (l *ScheduleLag) Add(lag time.Duration) {
+	l.Count++
+	l.Sum += lag
+	if lag > l.Max {
+		l.Max = lag
+	}
+}
+
+// Merge ...
+func (l *ScheduleLag) Merge(other ScheduleLag) {
+	l.Count += other.Count
+	l.Sum += other.Sum
+	if other.Max > l.Max {
+		l.Max = other.Max
+	}
+}
+
+// Mean ...
+func (l ScheduleLag) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Sum / time.Duration(l.Count)
 }
 
 // OpType ..
@@ -33,34 +103,173 @@ const (
 	Write OpType = iota
 	// Done ...
 	Done OpType = iota
-	// Deadlock ...
-	Deadlock OpType = iota
 )
 
+// ErrorKind classifies the failure of an operation.
+type ErrorKind int
+
+const (
+	// NoError ...
+	NoError ErrorKind = iota
+	// DeadlockError means that the transaction was aborted by the datastore,
+	// and could be retried.
+	DeadlockError ErrorKind = iota
+	// TimeoutError ...
+	TimeoutError ErrorKind = iota
+	// ConnectionError means that the connection to the system was lost or
+	// is unusable.
+	ConnectionError ErrorKind = iota
+	// ServerError is any other error returned by the system.
+	ServerError ErrorKind = iota
+	// NotImplementedError means that the measured system does not support
+	// the operation.
+	NotImplementedError ErrorKind = iota
+)
+
+var errorKindNames = [...]string{
+	NoError:             "none",
+	DeadlockError:       "deadlock",
+	TimeoutError:        "timeout",
+	ConnectionError:     "connection",
+	ServerError:         "server",
+	NotImplementedError: "notImplemented",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// ErrorKinds returns all error kinds, in the order they are reported.
+func ErrorKinds() []ErrorKind {
+	kinds := make([]ErrorKind, 0, len(errorKindNames)-1)
+	for i := range errorKindNames {
+		if ErrorKind(i) != NoError {
+			kinds = append(kinds, ErrorKind(i))
+		}
+	}
+	return kinds
+}
+
+// OpKind identifies the Lobsters endpoint exercised by an operation.
+type OpKind int
+
+const (
+	// Frontpage ...
+	Frontpage OpKind = iota
+	// Story ...
+	Story OpKind = iota
+	// User ...
+	User OpKind = iota
+	// Comments ...
+	Comments OpKind = iota
+	// Recent ...
+	Recent OpKind = iota
+	// Login ...
+	Login OpKind = iota
+	// Logout ...
+	Logout OpKind = iota
+	// StoryVote ...
+	StoryVote OpKind = iota
+	// CommentVote ...
+	CommentVote OpKind = iota
+	// Comment ...
+	Comment OpKind = iota
+	// EditComment ...
+	EditComment OpKind = iota
+	// Submit ...
+	Submit OpKind = iota
+)
+
+var opKindNames = [...]string{
+	Frontpage:   "frontpage",
+	Story:       "story",
+	User:        "user",
+	Comments:    "comments",
+	Recent:      "recent",
+	Login:       "login",
+	Logout:      "logout",
+	StoryVote:   "storyVote",
+	CommentVote: "commentVote",
+	Comment:     "comment",
+	EditComment: "editComment",
+	Submit:      "submit",
+}
+
+func (k OpKind) String() string {
+	return opKindNames[k]
+}
+
+// IsWrite returns true if the endpoint is a POST request.
+func (k OpKind) IsWrite() bool {
+	return k >= Login
+}
+
+// ParseOpKind returns the op kind with the given name.
+func ParseOpKind(name string) (OpKind, error) {
+	for i, n := range opKindNames {
+		if n == name {
+			return OpKind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown op kind: %s", name)
+}
+
+// OpKinds returns all op kinds, in the order they are reported.
+func OpKinds() []OpKind {
+	kinds := make([]OpKind, len(opKindNames))
+	for i := range opKindNames {
+		kinds[i] = OpKind(i)
+	}
+	return kinds
+}
+
 // Measurement ...
 type Measurement struct {
-	RespTime time.Duration
-	OpType   OpType
-	EndTs    time.Time
+	RespTime    time.Duration
+	OpKind      OpKind
+	OpType      OpType
+	Error       ErrorKind
+	EndTs       time.Time
+	ScheduledTs time.Time
 }
 
 // Metrics ...
 type Metrics struct {
-	Runtime        time.Duration
-	LoadOffered    float64
-	Throughput     float64
-	PerOpMetrics   map[string]OpMetrics
-	DeadlockAborts int64
+	Runtime      time.Duration
+	LoadOffered  float64
+	Throughput   float64
+	TotalMetrics OpMetrics
+	ReadMetrics  OpMetrics
+	WriteMetrics OpMetrics
+	PerOpMetrics map[string]OpMetrics
+	ScheduleLag  ScheduleLag
 }
 
 // OpMetrics ...
+// OpCount, Throughput and latency percentiles only account for successful
+// operations; failed ones are accounted in ErrorCount and the Error
+// percentiles.
+// The Corrected percentiles measure latency from the time an operation was
+// scheduled to be sent rather than from the time it was actually sent,
+// so that queueing delay in the generator is not omitted.
 type OpMetrics struct {
-	OpCount    int64
-	Throughput float64
-	P50        float64
-	P90        float64
-	P95        float64
-	P99        float64
+	OpCount      int64
+	Throughput   float64
+	P50          float64
+	P90          float64
+	P95          float64
+	P99          float64
+	CorrectedP50 float64
+	CorrectedP90 float64
+	CorrectedP95 float64
+	CorrectedP99 float64
+	ErrorCount   int64
+	// error kind -> count
+	Errors   map[string]int64
+	ErrorP50 float64
+	ErrorP90 float64
+	ErrorP95 float64
+	ErrorP99 float64
 }
 
 var (
@@ -83,7 +292,7 @@ func NewHistogram() *stats.Histogram {
 	return stats.NewHistogram(histogramOpts)
 }
 
-//ReportMeasurements ...
+// ReportMeasurements ...
 func (p *Measurements) ReportMeasurements(m ClientMeasurements) {
 	p.Lock()
 	p.clientMeasurements = append(p.clientMeasurements, m)
@@ -91,73 +300,164 @@ func (p *Measurements) ReportMeasurements(m ClientMeasurements) {
 }
 
 // CalculateMetrics ...
-func (p *Measurements) CalculateMetrics(fTRead, fTWrite *os.File) (Metrics, error) {
+func (p *Measurements) CalculateMetrics(fTRead, fTWrite io.Writer) (Metrics, error) {
 	var aggOpsOffered int64
-	var aggDeadlockAborts int64
 	var aggRuntime time.Duration
 
 	aggHistograms := make(map[string]*stats.Histogram)
-	aggHistograms["read"] = stats.NewHistogram(histogramOpts)
-	aggHistograms["write"] = stats.NewHistogram(histogramOpts)
+	aggCorrectedHistograms := make(map[string]*stats.Histogram)
+	aggErrors := make(map[string]*OpErrors)
+	readHistogram, readCorrectedHistogram := NewHistogram(), NewHistogram()
+	writeHistogram, writeCorrectedHistogram := NewHistogram(), NewHistogram()
+	readErrors, writeErrors := NewOpErrors(), NewOpErrors()
 
-	traceF := make(map[string]*os.File)
-	traceF["read"] = fTRead
-	traceF["write"] = fTWrite
+	m := Metrics{
+		PerOpMetrics: make(map[string]OpMetrics),
+	}
 
 	for _, c := range p.clientMeasurements {
 		aggRuntime += c.Runtime
 		aggOpsOffered += c.OpsOffered
-		aggDeadlockAborts += c.DeadlockAborts
+		m.ScheduleLag.Merge(c.ScheduleLag)
 
-		for opType, hist := range c.Histograms {
-			aggHistograms[opType].Merge(hist)
+		mergeHistograms(aggHistograms, c.Histograms)
+		mergeHistograms(aggCorrectedHistograms, c.CorrectedHistograms)
+		for opKind, errs := range c.Errors {
+			if _, ok := aggErrors[opKind]; !ok {
+				aggErrors[opKind] = NewOpErrors()
+			}
+			aggErrors[opKind].Merge(errs)
 		}
-
-	}
-
-	m := Metrics{
-		PerOpMetrics: make(map[string]OpMetrics),
 	}
 
 	m.Runtime = aggRuntime / time.Duration(len(p.clientMeasurements))
 	m.LoadOffered = float64(aggOpsOffered) / aggRuntime.Seconds() * float64(len(p.clientMeasurements))
 
 	var totalOpCnt int64
-	for opType, hist := range aggHistograms {
-		totalOpCnt += hist.Count
-		opMetrics := OpMetrics{
-			OpCount:    hist.Count,
-			Throughput: float64(hist.Count) / aggRuntime.Seconds(),
-			P50:        durationToMillis(time.Duration(pepcentile(.5, hist))),
-			P90:        durationToMillis(time.Duration(pepcentile(.9, hist))),
-			P95:        durationToMillis(time.Duration(pepcentile(.95, hist))),
-			P99:        durationToMillis(time.Duration(pepcentile(.99, hist))),
+	for _, opKind := range OpKinds() {
+		hist, ok := aggHistograms[opKind.String()]
+		errs, errOk := aggErrors[opKind.String()]
+		if !ok && !errOk {
+			continue
 		}
-
-		if _, err := fmt.Fprintf(traceF[opType], "%.5f\n", aggRuntime.Seconds()); err != nil {
-			return m, err
+		// an op kind can have only failed, or only successful operations
+		if !ok {
+			hist = NewHistogram()
+		}
+		correctedHist, ok := aggCorrectedHistograms[opKind.String()]
+		if !ok {
+			correctedHist = NewHistogram()
 		}
-		if _, err := fmt.Fprintf(traceF[opType], "%d\n", hist.Count); err != nil {
-			return m, err
+		if !errOk {
+			errs = NewOpErrors()
 		}
+		totalOpCnt += hist.Count
+		m.PerOpMetrics[opKind.String()] = calculateOpMetrics(hist, correctedHist, errs, m.Runtime)
 
-		m.PerOpMetrics[opType] = opMetrics
+		if opKind.IsWrite() {
+			writeHistogram.Merge(hist)
+			writeCorrectedHistogram.Merge(correctedHist)
+			writeErrors.Merge(errs)
+		} else {
+			readHistogram.Merge(hist)
+			readCorrectedHistogram.Merge(correctedHist)
+			readErrors.Merge(errs)
+		}
 	}
 
-	m.Throughput = float64(totalOpCnt) / aggRuntime.Seconds() * float64(len(p.clientMeasurements))
+	totalHistogram, totalCorrectedHistogram, totalErrors := NewHistogram(), NewHistogram(), NewOpErrors()
+	totalHistogram.Merge(readHistogram)
+	totalHistogram.Merge(writeHistogram)
+	totalCorrectedHistogram.Merge(readCorrectedHistogram)
+	totalCorrectedHistogram.Merge(writeCorrectedHistogram)
+	totalErrors.Merge(readErrors)
+	totalErrors.Merge(writeErrors)
+
+	m.TotalMetrics = calculateOpMetrics(totalHistogram, totalCorrectedHistogram, totalErrors, m.Runtime)
+	m.ReadMetrics = calculateOpMetrics(readHistogram, readCorrectedHistogram, readErrors, m.Runtime)
+	m.WriteMetrics = calculateOpMetrics(writeHistogram, writeCorrectedHistogram, writeErrors, m.Runtime)
+
+	if err := writeTrace(fTRead, aggRuntime, readHistogram); err != nil {
+		return m, err
+	}
+	if err := writeTrace(fTWrite, aggRuntime, writeHistogram); err != nil {
+		return m, err
+	}
 
-	m.DeadlockAborts = aggDeadlockAborts
+	m.Throughput = float64(totalOpCnt) / aggRuntime.Seconds() * float64(len(p.clientMeasurements))
 
 	return m, nil
 }
 
+// TimeSeries merges the time series collected by all clients.
+func (p *Measurements) TimeSeries() *TimeSeries {
+	var ts *TimeSeries
+	for _, c := range p.clientMeasurements {
+		if ts == nil {
+			ts = NewTimeSeries(c.TimeSeries.Interval)
+		}
+		ts.Merge(c.TimeSeries)
+	}
+	return ts
+}
+
+func mergeHistograms(agg, histograms map[string]*stats.Histogram) {
+	for opKind, hist := range histograms {
+		if _, ok := agg[opKind]; !ok {
+			agg[opKind] = NewHistogram()
+		}
+		agg[opKind].Merge(hist)
+	}
+}
+
+func calculateOpMetrics(hist, correctedHist *stats.Histogram, errs *OpErrors, runtime time.Duration) OpMetrics {
+	errCounts := make(map[string]int64, len(errs.Counts))
+	for errKind, count := range errs.Counts {
+		errCounts[errKind] = count
+	}
+
+	return OpMetrics{
+		OpCount:      hist.Count,
+		Throughput:   float64(hist.Count) / runtime.Seconds(),
+		P50:          durationToMillis(time.Duration(pepcentile(.5, hist))),
+		P90:          durationToMillis(time.Duration(pepcentile(.9, hist))),
+		P95:          durationToMillis(time.Duration(pepcentile(.95, hist))),
+		P99:          durationToMillis(time.Duration(pepcentile(.99, hist))),
+		CorrectedP50: durationToMillis(time.Duration(pepcentile(.5, correctedHist))),
+		CorrectedP90: durationToMillis(time.Duration(pepcentile(.9, correctedHist))),
+		CorrectedP95: durationToMillis(time.Duration(pepcentile(.95, correctedHist))),
+		CorrectedP99: durationToMillis(time.Duration(pepcentile(.99, correctedHist))),
+		ErrorCount:   errs.Histogram.Count,
+		Errors:       errCounts,
+		ErrorP50:     durationToMillis(time.Duration(pepcentile(.5, errs.Histogram))),
+		ErrorP90:     durationToMillis(time.Duration(pepcentile(.9, errs.Histogram))),
+		ErrorP95:     durationToMillis(time.Duration(pepcentile(.95, errs.Histogram))),
+		ErrorP99:     durationToMillis(time.Duration(pepcentile(.99, errs.Histogram))),
+	}
+}
+
+func writeTrace(f io.Writer, runtime time.Duration, hist *stats.Histogram) error {
+	if _, err := fmt.Fprintf(f, "%.5f\n", runtime.Seconds()); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(f, "%d\n", hist.Count)
+	return err
+}
+
 func pepcentile(percentile float64, h *stats.Histogram) int64 {
+	return histogramPercentile(percentile, h, histogramOpts)
+}
+
+func histogramPercentile(percentile float64, h *stats.Histogram, opts stats.HistogramOptions) int64 {
+	if h.Count == 0 {
+		return 0
+	}
 	percentileCount := int64(float64(h.Count) * percentile)
 	currentCount := int64(0)
 	for _, bucket := range h.Buckets {
 		if currentCount+bucket.Count >= percentileCount {
 			lastBuckedFilled := float64(percentileCount-currentCount) / float64(bucket.Count)
-			return int64((1.0-lastBuckedFilled)*bucket.LowBound + lastBuckedFilled*bucket.LowBound*(1.0+histogramOpts.GrowthFactor))
+			return int64((1.0-lastBuckedFilled)*bucket.LowBound + lastBuckedFilled*bucket.LowBound*(1.0+opts.GrowthFactor))
 		}
 		currentCount += bucket.Count
 	}
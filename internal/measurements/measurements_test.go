@@ -0,0 +1,87 @@
+package measurements
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/benchmark/stats"
+)
+
+// stalledClient returns the measurements of an open-loop client whose
+// operations each took respTime, but that stalled, so that its i-th
+// operation of each op kind was sent i ms late.
+func stalledClient(respTime time.Duration, ops int, opKinds ...OpKind) ClientMeasurements {
+	m := ClientMeasurements{
+		Runtime:             10 * time.Second,
+		Histograms:          make(map[string]*stats.Histogram),
+		CorrectedHistograms: make(map[string]*stats.Histogram),
+		Errors:              make(map[string]*OpErrors),
+	}
+	for _, opKind := range opKinds {
+		hist, correctedHist := NewHistogram(), NewHistogram()
+		for i := 1; i <= ops; i++ {
+			hist.Add(respTime.Nanoseconds())
+			correctedHist.Add((respTime + time.Duration(i)*time.Millisecond).Nanoseconds())
+		}
+		m.Histograms[opKind.String()] = hist
+		m.CorrectedHistograms[opKind.String()] = correctedHist
+		m.OpsOffered += int64(ops)
+	}
+	return m
+}
+
+func TestCorrectedPercentiles(t *testing.T) {
+	p := New()
+	p.ReportMeasurements(stalledClient(time.Millisecond, 100, Frontpage, StoryVote))
+	p.ReportMeasurements(stalledClient(time.Millisecond, 100, Frontpage, StoryVote))
+
+	m, err := p.CalculateMetrics(ioutil.Discard, ioutil.Discard)
+	assert.NoError(t, err)
+
+	for name, opMetrics := range map[string]OpMetrics{
+		"frontpage": m.PerOpMetrics[Frontpage.String()],
+		"storyVote": m.PerOpMetrics[StoryVote.String()],
+		"read":      m.ReadMetrics,
+		"write":     m.WriteMetrics,
+		"total":     m.TotalMetrics,
+	} {
+		// the uncorrected latency does not see the stall
+		assert.InEpsilon(t, 1, opMetrics.P50, .02, name)
+		assert.InEpsilon(t, 1, opMetrics.P99, .02, name)
+		// the corrected latency is 2..101ms
+		assert.InEpsilon(t, 51, opMetrics.CorrectedP50, .02, name)
+		assert.InEpsilon(t, 91, opMetrics.CorrectedP90, .02, name)
+		assert.InEpsilon(t, 96, opMetrics.CorrectedP95, .02, name)
+		assert.InEpsilon(t, 100, opMetrics.CorrectedP99, .02, name)
+	}
+	assert.Equal(t, int64(400), m.TotalMetrics.OpCount)
+}
+
+func TestCorrectedPercentilesOnlyErrors(t *testing.T) {
+	// an op kind with only failed operations has no corrected histogram
+	errs := NewOpErrors()
+	for i := 0; i < 10; i++ {
+		errs.Add(Measurement{OpKind: Submit, Error: TimeoutError, RespTime: 100 * time.Millisecond})
+	}
+
+	p := New()
+	p.ReportMeasurements(ClientMeasurements{
+		Runtime:             10 * time.Second,
+		OpsOffered:          10,
+		Histograms:          make(map[string]*stats.Histogram),
+		CorrectedHistograms: make(map[string]*stats.Histogram),
+		Errors:              map[string]*OpErrors{Submit.String(): errs},
+	})
+
+	m, err := p.CalculateMetrics(ioutil.Discard, ioutil.Discard)
+	assert.NoError(t, err)
+
+	submit := m.PerOpMetrics[Submit.String()]
+	assert.Equal(t, int64(0), submit.OpCount)
+	assert.Equal(t, float64(0), submit.CorrectedP99)
+	assert.Equal(t, int64(10), submit.ErrorCount)
+	assert.Equal(t, map[string]int64{TimeoutError.String(): 10}, submit.Errors)
+	assert.InEpsilon(t, 100, submit.ErrorP99, .02)
+}
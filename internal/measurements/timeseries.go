@@ -0,0 +1,130 @@
+package measurements
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/benchmark/stats"
+)
+
+// TimeSeries buckets completed operations into fixed, wall-clock aligned
+// intervals, so that throughput and latency can be plotted over a run.
+type TimeSeries struct {
+	Interval time.Duration
+	// bucket index (wall-clock time / Interval) -> op kind -> stats
+	Buckets map[int64]map[string]*IntervalStats
+}
+
+// IntervalStats ...
+type IntervalStats struct {
+	OpCount   int64
+	Errors    int64
+	Histogram *stats.Histogram
+}
+
+var (
+	// Per-interval histograms are kept for every op kind of every interval,
+	// so they use coarser (5%) buckets than the run-wide ones.
+	timeSeriesHistogramOpts = stats.HistogramOptions{
+		NumBuckets:   500,
+		GrowthFactor: .05,
+	}
+)
+
+// NewTimeSeries ...
+func NewTimeSeries(interval time.Duration) *TimeSeries {
+	return &TimeSeries{
+		Interval: interval,
+		Buckets:  make(map[int64]map[string]*IntervalStats),
+	}
+}
+
+// Add records a completed operation in the interval it completed in.
+func (ts *TimeSeries) Add(m Measurement) {
+	s := ts.intervalStats(m.EndTs.UnixNano()/int64(ts.Interval), m.OpKind.String())
+	if m.Error != NoError {
+		s.Errors++
+		return
+	}
+	s.OpCount++
+	s.Histogram.Add(m.RespTime.Nanoseconds())
+}
+
+// Merge ...
+func (ts *TimeSeries) Merge(other *TimeSeries) {
+	for bucket, perOp := range other.Buckets {
+		for opKind, o := range perOp {
+			s := ts.intervalStats(bucket, opKind)
+			s.OpCount += o.OpCount
+			s.Errors += o.Errors
+			s.Histogram.Merge(o.Histogram)
+		}
+	}
+}
+
+func (ts *TimeSeries) intervalStats(bucket int64, opKind string) *IntervalStats {
+	perOp, ok := ts.Buckets[bucket]
+	if !ok {
+		perOp = make(map[string]*IntervalStats)
+		ts.Buckets[bucket] = perOp
+	}
+	s, ok := perOp[opKind]
+	if !ok {
+		s = &IntervalStats{Histogram: stats.NewHistogram(timeSeriesHistogramOpts)}
+		perOp[opKind] = s
+	}
+	return s
+}
+
+// Print writes the time series as CSV, one row per interval and op kind,
+// plus a "total" row per interval.
+func (ts *TimeSeries) Print(f *os.File) error {
+	if _, err := fmt.Fprintln(f, "timestamp_ms,elapsed_s,op,count,errors,throughput,p50_ms,p90_ms,p95_ms,p99_ms"); err != nil {
+		return err
+	}
+
+	buckets := make([]int64, 0, len(ts.Buckets))
+	for bucket := range ts.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	for _, bucket := range buckets {
+		total := &IntervalStats{Histogram: stats.NewHistogram(timeSeriesHistogramOpts)}
+		for _, opKind := range OpKinds() {
+			s, ok := ts.Buckets[bucket][opKind.String()]
+			if !ok {
+				continue
+			}
+			if err := ts.printInterval(f, bucket, buckets[0], opKind.String(), s); err != nil {
+				return err
+			}
+			total.OpCount += s.OpCount
+			total.Errors += s.Errors
+			total.Histogram.Merge(s.Histogram)
+		}
+		if err := ts.printInterval(f, bucket, buckets[0], "total", total); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ts *TimeSeries) printInterval(f *os.File, bucket, first int64, opKind string, s *IntervalStats) error {
+	_, err := fmt.Fprintf(f, "%d,%.3f,%s,%d,%d,%.5f,%.5f,%.5f,%.5f,%.5f\n",
+		time.Duration(bucket*int64(ts.Interval)).Milliseconds(),
+		time.Duration((bucket-first)*int64(ts.Interval)).Seconds(),
+		opKind,
+		s.OpCount,
+		s.Errors,
+		float64(s.OpCount)/ts.Interval.Seconds(),
+		durationToMillis(time.Duration(histogramPercentile(.5, s.Histogram, timeSeriesHistogramOpts))),
+		durationToMillis(time.Duration(histogramPercentile(.9, s.Histogram, timeSeriesHistogramOpts))),
+		durationToMillis(time.Duration(histogramPercentile(.95, s.Histogram, timeSeriesHistogramOpts))),
+		durationToMillis(time.Duration(histogramPercentile(.99, s.Histogram, timeSeriesHistogramOpts))),
+	)
+	return err
+}
@@ -1,13 +1,13 @@
 package operations
 
 import (
+	"context"
+	"database/sql/driver"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
-
-	//"runtime/debug"
+	"net"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -18,86 +18,121 @@ import (
 	"github.com/dvasilas/proteus-lobsters-bench/internal/distributions"
 	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
 	queryengine "github.com/dvasilas/proteus-lobsters-bench/internal/query-engine"
-	workerpool "github.com/dvasilas/proteus-lobsters-bench/internal/worker_pool"
 	"github.com/dvasilas/proteus/pkg/proteus-go-client/pb"
 	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Operations ...
 type Operations struct {
-	config              *config.BenchmarkConfig
-	qeProteus           queryengine.QueryEngine
-	qeLobsters          queryengine.QueryEngine
-	ds                  datastore.Datastore
-	storyVoteSampler    distributions.Sampler
-	commentVoteSampler  distributions.Sampler
-	commentStorySampler distributions.Sampler
-	StoryID             int64
-	topStories          []int64
-	voteDistribution    config.DistributionType
-	dispatcherQ         *workerpool.Dispatcher
-	dispatcherW         *workerpool.Dispatcher
-}
+	config           *config.BenchmarkConfig
+	system           System
+	ds               datastore.Datastore
+	storyVoteDist    distributions.Distribution
+	storyReadDist    distributions.Distribution
+	commentStoryDist distributions.Distribution
+	// the story distributions that new stories are added to
+	newStories         []distributions.Growing
+	commentVoteSampler distributions.Sampler
+	userSampler        *distributions.Sampler
+	StoryID            int64
+	UserID             int64
+	topStories         []int64
+	voteDistribution   config.DistributionType
+	queries            readQueries
+}
+
+// readQueries are the read queries that depend on the configuration,
+// formatted once at startup.
+type readQueries struct {
+	frontpage string
+	recent    string
+	comments  string
+}
+
+const (
+	storyQuery        = "SELECT title, description, short_id, user_id, vote_sum FROM stories WHERE short_id = ?"
+	userQuery         = "SELECT id, username FROM users WHERE username = ?"
+	userStoriesQuery  = "SELECT COUNT(*) FROM stories WHERE user_id = ?"
+	userCommentsQuery = "SELECT COUNT(*) FROM comments WHERE user_id = ?"
+	loginQuery        = "SELECT 1 AS one FROM users WHERE username = ?"
+	editCommentQuery  = "SELECT id, story_id, user_id, comment FROM comments WHERE id = ?"
+)
 
 // Operation ...
 type Operation interface {
-	DoOperation(int64) (measurements.OpType, time.Duration, time.Time)
+	DoOperation(context.Context, int64) measurements.Measurement
 }
 
 // NewOperations ...
 func NewOperations(conf *config.BenchmarkConfig) (*Operations, error) {
-	rand.Seed(time.Now().UTC().UnixNano())
 	var ds datastore.Datastore
-	var qeProteus, qeLobsters queryengine.QueryEngine
-	var err error
 
-	if conf.Benchmark.MeasuredSystem == "baseline" || conf.Benchmark.MeasuredSystem == "baseline_workers" {
-		ds, err = datastore.NewDatastore(conf.Connection.DBEndpoint, conf.Connection.Database, conf.Connection.AccessKeyID, conf.Connection.SecretAccessKey)
+	newSystem, err := lookupSystem(conf.Benchmark.MeasuredSystem)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Benchmark.DoPreload {
+		// the initial data set is written directly to the datastore,
+		// whatever the measured system
+		newSystem = newBaselineSystem
+	}
+
+	if conf.Connection.DBEndpoint != "" {
+		ds, err = datastore.NewDatastore(conf.Connection.DBEndpoint, conf.Connection.Database, conf.Connection.AccessKeyID, conf.Connection.SecretAccessKey, conf.Connection.StatementMode)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if !conf.Benchmark.DoPreload && conf.Operations.WriteRatio < 1.0 {
-		switch conf.Benchmark.MeasuredSystem {
-		case "proteus":
-			qeProteus, err = queryengine.NewProteusQE(conf.Connection.ProteusEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
-			if err != nil {
-				return nil, err
-			}
-			qeLobsters, err = queryengine.NewProteusQE(conf.Connection.LobstersEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
-			if err != nil {
-				return nil, err
-			}
-		case "mysql":
-			qeLobsters, err = queryengine.NewMysqlQE(conf.Connection.ProteusEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
-			if err != nil {
-				return nil, err
-			}
-		case "baseline":
-			qeLobsters = queryengine.NewBaselineQE(&ds)
-		case "baseline_workers":
-			qeLobsters = queryengine.NewBaselineQE(&ds)
-		default:
-			return nil, errors.New("invalid 'system' argument")
+	queries := readQueries{
+		frontpage: fmt.Sprintf("SELECT title, description, short_id, user_id, vote_sum FROM stories ORDER BY vote_sum DESC LIMIT %d",
+			conf.Operations.Homepage.StoriesLimit),
+		recent: fmt.Sprintf("SELECT id, title, description, short_id, user_id, vote_sum FROM stories ORDER BY id DESC LIMIT %d",
+			conf.Operations.Homepage.StoriesLimit),
+		comments: fmt.Sprintf("SELECT id, story_id, user_id, comment FROM comments ORDER BY id DESC LIMIT %d",
+			conf.Operations.Comments.CommentsLimit),
+	}
+
+	switch conf.Operations.DuplicateVotes {
+	case "", "allow":
+	case "prevent":
+		ds.UniqueVotes = true
+	default:
+		return nil, errors.New("unexpected duplicate votes policy")
+	}
+
+	if ds.Db != nil {
+		err = ds.Prepare(
+			queries.frontpage,
+			queries.recent,
+			queries.comments,
+			storyQuery,
+			userQuery,
+			userStoriesQuery,
+			userCommentsQuery,
+			loginQuery,
+			editCommentQuery,
+		)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	ops := &Operations{
-		config:              conf,
-		qeProteus:           qeProteus,
-		qeLobsters:          qeLobsters,
-		ds:                  ds,
-		storyVoteSampler:    distributions.NewSampler(conf.Distributions.VotesPerStory),
-		commentVoteSampler:  distributions.NewSampler(conf.Distributions.VotesPerComment),
-		commentStorySampler: distributions.NewSampler(conf.Distributions.CommentsPerStory),
-		StoryID:             conf.Preload.RecordCount.Stories,
-		dispatcherQ:         workerpool.NewDispatcher(int(conf.WorkerPoolSizeQ), int(conf.JobQueueSizeQ)),
-		dispatcherW:         workerpool.NewDispatcher(int(conf.WorkerPoolSizeW), int(conf.JobQueueSizeW)),
+		config:  conf,
+		ds:      ds,
+		StoryID: conf.Preload.RecordCount.Stories,
+		UserID:  conf.Preload.RecordCount.Users,
+		queries: queries,
 	}
 
-	ops.dispatcherQ.Run()
-	ops.dispatcherW.Run()
+	ops.system, err = newSystem(conf, &ops.ds)
+	if err != nil {
+		return nil, err
+	}
 
 	switch conf.Operations.DistributionType {
 	case "uniform":
@@ -110,6 +145,43 @@ func NewOperations(conf *config.BenchmarkConfig) (*Operations, error) {
 		return nil, errors.New("unexpected distribution type")
 	}
 
+	voteDistribution := conf.Operations.StoryVoteDistribution
+	if voteDistribution == "" {
+		voteDistribution = conf.Operations.DistributionType
+	}
+	if voteDistribution == "voteTopStories" {
+		// for votes that do not go to the top stories
+		voteDistribution = "histogram"
+	}
+	ops.commentVoteSampler, err = distributions.NewSamplerMode(conf.Distributions.SamplerMode, conf.Distributions.VotesPerComment, conf.Distributions.Averages.VotesPerComment)
+	if err != nil {
+		return nil, err
+	}
+	ops.storyVoteDist, err = ops.storyDistribution(voteDistribution, conf.Distributions.VotesPerStory, conf.Distributions.Averages.VotesPerStory)
+	if err != nil {
+		return nil, err
+	}
+	ops.storyReadDist, err = ops.storyDistribution(conf.Operations.StoryReadDistribution, conf.Distributions.VotesPerStory, conf.Distributions.Averages.VotesPerStory)
+	if err != nil {
+		return nil, err
+	}
+	ops.commentStoryDist, err = ops.storyDistribution(conf.Operations.CommentStoryDistribution, conf.Distributions.CommentsPerStory, conf.Distributions.Averages.CommentsPerStory)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.Operations.UserDistribution {
+	case "", "uniform":
+	case "histogram":
+		sampler, err := distributions.NewSamplerMode(conf.Distributions.SamplerMode, conf.Distributions.ActionsPerUser, nil)
+		if err != nil {
+			return nil, err
+		}
+		ops.userSampler = &sampler
+	default:
+		return nil, errors.New("unexpected user distribution")
+	}
+
 	if ops.voteDistribution == config.VoteTopStories {
 		topStories, err := ops.getTopStories()
 		if err != nil {
@@ -123,112 +195,110 @@ func NewOperations(conf *config.BenchmarkConfig) (*Operations, error) {
 
 // StoryVote ...
 type StoryVote struct {
-	Ops  *Operations
-	Vote int
+	Ops     *Operations
+	Vote    int
+	StoryID int64
+	UserID  int64
 }
 
 // DoOperation ...
-func (op StoryVote) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.StoryVote(op.Vote, opID)
-	if err != nil {
-		if strings.Contains(err.Error(), "Deadlock") {
-			return measurements.Deadlock, respTime, time.Now()
-		} else if strings.Contains(err.Error(), "out of sync") || strings.Contains(err.Error(), "bad connection") || err == mysql.ErrInvalidConn {
-			// er(err)
-			return measurements.Deadlock, respTime, time.Now()
-		}
-	}
-	return measurements.Write, respTime, time.Now()
+func (op StoryVote) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.StoryVote(ctx, op.UserID, op.StoryID, op.Vote, opID)
+	return measurement(measurements.StoryVote, measurements.Write, respTime, err)
 }
 
-// StoryVote issues an up or down vote for the given story.
-func (op *Operations) StoryVote(vote int, opID int64) (time.Duration, error) {
+// VoteStoryID picks the story to be voted, according to the configured
+// vote distribution, for an operation scheduled at the given offset.
+func (op *Operations) VoteStoryID(r *rand.Rand, at time.Duration) int64 {
 	var storyID int64
-	var err error
 	for storyID == 0 {
-		switch op.voteDistribution {
-		case config.VoteTopStories:
-			r := rand.Float64()
-			if r < op.config.Operations.VoteTopStoriesP {
-				fmt.Println("top")
-				storyID = op.topStories[rand.Intn(len(op.topStories))]
-			} else {
-				fmt.Println("histo")
-				storyID = op.storyVoteSampler.Sample()
-			}
-		case config.Histogram:
-			storyID = op.storyVoteSampler.Sample()
-		case config.Uniform:
-			storyID = rand.Int63n(op.config.Preload.RecordCount.Stories)
+		if op.voteDistribution == config.VoteTopStories && r.Float64() < op.config.Operations.VoteTopStoriesP {
+			storyID = op.topStories[r.Intn(len(op.topStories))]
+		} else {
+			storyID = sampleStory(op.storyVoteDist, r, at)
 		}
 	}
-	st := time.Now()
-	if op.config.Benchmark.MeasuredSystem == "proteus" {
-		// err = op.ds.StoryVoteSimple(1, storyID, vote)
-		err = op.qeLobsters.StoryVote(storyID, vote, opID)
-	} else if op.config.Benchmark.MeasuredSystem == "mysql" {
-		err = op.qeLobsters.StoryVote(storyID, vote, opID)
-	} else if op.config.Benchmark.MeasuredSystem == "baseline" {
-		err = op.ds.StoryVoteUpdateCount(1, storyID, vote)
-	} else if op.config.Benchmark.MeasuredSystem == "baseline_workers" {
-		work := &JobStoryVote{
-			ops:     op,
-			storyID: storyID,
-			vote:    vote,
-			result:  &jobStoryVoteResult{},
-			done:    make(chan bool),
-		}
-
-		op.dispatcherW.JobQueue <- work
-
-		<-work.done
-
-		err = work.result.err
-	}
-	return time.Since(st), err
+	return storyID
 }
 
-// JobStoryVote ...
-type JobStoryVote struct {
-	ops     *Operations
-	result  *jobStoryVoteResult
-	storyID int64
-	vote    int
-	done    chan bool
-}
+// storyDistribution creates the named distribution of story IDs ("histogram"
+// if unset), where "histogram" follows hist, with the given bin averages.
+// Unless disabled, stories submitted during the benchmark (but not preloaded
+// ones) are added to it, once they are inserted.
+func (op *Operations) storyDistribution(name string, hist []config.Bin, averages []float64) (distributions.Distribution, error) {
+	d, err := op.preloadedStoryDistribution(name, hist, averages)
+	if err != nil {
+		return nil, err
+	}
+	if latest, ok := d.(distributions.Latest); ok {
+		op.newStories = append(op.newStories, latest)
+		return d, nil
+	}
+	if op.config.Operations.NewStories.InitialWeight <= 0 || op.config.Benchmark.DoPreload {
+		return d, nil
+	}
 
-// Do ...
-func (j *JobStoryVote) Do() {
-	j.do()
-	j.done <- true
+	newStories := op.config.Operations.NewStories
+	dynamic := distributions.NewDynamic(d, op.config.Preload.RecordCount.Stories, newStories.InitialWeight, time.Duration(newStories.HalfLife)*time.Second)
+	op.newStories = append(op.newStories, dynamic)
+	return dynamic, nil
 }
 
-func (j *JobStoryVote) do() {
-	j.result.err = j.ops.ds.StoryVoteUpdateCount(1, j.storyID, j.vote)
+func (op *Operations) preloadedStoryDistribution(name string, hist []config.Bin, averages []float64) (distributions.Distribution, error) {
+	stories := op.config.Preload.RecordCount.Stories
+	switch name {
+	case "", "histogram":
+		return distributions.NewSamplerMode(op.config.Distributions.SamplerMode, hist, averages)
+	case "uniform":
+		return distributions.NewUniform(stories), nil
+	case "zipf":
+		return distributions.NewZipf(stories, op.config.Operations.Zipf.Theta)
+	case "hotspot":
+		return distributions.NewHotspot(stories, op.config.Operations.Hotspot.KeyFraction, op.config.Operations.Hotspot.OpFraction)
+	case "latest":
+		// favor the stories submitted during the benchmark
+		return distributions.NewLatest(stories, op.config.Operations.Zipf.Theta)
+	default:
+		return nil, errors.New("unexpected story distribution")
+	}
 }
 
-type jobStoryVoteResult struct {
-	err error
+// StoryVote issues an up or down vote of the given user for the given story.
+func (op *Operations) StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) (time.Duration, error) {
+	st := time.Now()
+	err := op.system.StoryVote(ctx, userID, storyID, vote, opID)
+	return time.Since(st), err
 }
 
 // CommentVote ...
 type CommentVote struct {
-	Ops  *Operations
-	Vote int
+	Ops       *Operations
+	Vote      int
+	CommentID int64
+	UserID    int64
 }
 
 // DoOperation ...
-func (op CommentVote) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.CommentVote(op.Vote)
-	if err != nil {
-		er(err)
+func (op CommentVote) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.CommentVote(ctx, op.UserID, op.CommentID, op.Vote, opID)
+	return measurement(measurements.CommentVote, measurements.Write, respTime, err)
+}
+
+// VoteCommentID picks the comment to be voted or edited.
+func (op *Operations) VoteCommentID(r *rand.Rand) int64 {
+	var commentID int64
+	for commentID == 0 {
+		commentID = op.commentVoteSampler.Sample(r)
 	}
-	return measurements.Write, respTime, time.Now()
+	return commentID
 }
 
-// CommentVote issues an up or down vote for the given comment.
-func (op *Operations) CommentVote(vote int) (time.Duration, error) {
-	return 0, errors.New("not implemented")
+// CommentVote issues an up or down vote of the given user for the given
+// comment.
+func (op *Operations) CommentVote(ctx context.Context, userID, commentID int64, vote int, opID int64) (time.Duration, error) {
+	st := time.Now()
+	err := op.system.CommentVote(ctx, userID, commentID, vote, opID)
+	return time.Since(st), err
 }
 
 // Frontpage ...
@@ -237,30 +307,22 @@ type Frontpage struct {
 }
 
 // DoOperation ...
-func (op Frontpage) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.Frontpage(opID)
-	if err != nil {
-		er(err)
-		return measurements.Deadlock, respTime, time.Now()
-	}
-	return measurements.Read, respTime, time.Now()
+func (op Frontpage) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Frontpage(ctx, opID)
+	return measurement(measurements.Frontpage, measurements.Read, respTime, err)
 }
 
 // GetTopStories ...
 func (op *Operations) getTopStories() ([]int64, error) {
 	topStories := make([]int64, op.config.Operations.Homepage.StoriesLimit)
-	queryStr := fmt.Sprintf("SELECT title, description, short_id, user_id, vote_sum FROM stories ORDER BY vote_sum DESC LIMIT %d",
-		op.config.Operations.Homepage.StoriesLimit)
-
-	resp, err := op.qeProteus.Query(queryStr, 0)
+	_, resp, err := op.readQuery(context.Background(), measurements.Frontpage, op.queries.frontpage, 0)
 	if err != nil {
 		return topStories, err
 	}
 
 	// hp := Homepage{}
-	switch op.config.Benchmark.MeasuredSystem {
-	case "proteus":
-		response := resp.(*pb.QueryResp)
+	switch response := resp.(type) {
+	case *pb.QueryResp:
 		for i, entry := range response.GetRespRecord() {
 			sID, err := strconv.ParseInt(entry.GetAttributes()["story_id"], 10, 64)
 			if err != nil {
@@ -274,228 +336,459 @@ func (op *Operations) getTopStories() ([]int64, error) {
 }
 
 // Frontpage renders the frontpage (https://lobste.rs/).
-func (op *Operations) Frontpage(opID int64) (time.Duration, error) {
-	queryStr := fmt.Sprintf("SELECT title, description, short_id, user_id, vote_sum FROM stories ORDER BY vote_sum DESC LIMIT %d",
-		op.config.Operations.Homepage.StoriesLimit)
-
-	var duration time.Duration
-	var err error
-	st := time.Now()
-	if op.config.Benchmark.MeasuredSystem == "baseline_workers" {
-		work := &JobFrontPage{
-			ops:      op,
-			queryStr: queryStr,
-			opID:     opID,
-			result:   &jobFrontPageResult{},
-			done:     make(chan bool),
-		}
+func (op *Operations) Frontpage(ctx context.Context, opID int64) (time.Duration, error) {
+	duration, _, err := op.readQuery(ctx, measurements.Frontpage, op.queries.frontpage, opID)
+	return duration, err
+}
 
-		op.dispatcherQ.JobQueue <- work
+// Story ...
+type Story struct {
+	Ops     *Operations
+	StoryID int64
+}
 
-		<-work.done
+// DoOperation ...
+func (op Story) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Story(ctx, op.StoryID, opID)
+	return measurement(measurements.Story, measurements.Read, respTime, err)
+}
 
-		err = work.result.err
-	} else if op.config.Benchmark.MeasuredSystem == "proteus" {
-		_, err = op.qeProteus.Query(queryStr, opID)
-	} else {
-		_, err = op.qeLobsters.Query(queryStr, opID)
+// ReadStoryID picks the story to be read, for an operation scheduled at the
+// given offset.
+func (op *Operations) ReadStoryID(r *rand.Rand, at time.Duration) int64 {
+	var storyID int64
+	for storyID == 0 {
+		storyID = sampleStory(op.storyReadDist, r, at)
 	}
-	duration = time.Since(st)
+	return storyID
+}
 
-	if err != nil {
-		return duration, err
+// Story renders a particular story based a given shortID (https://lobste.rs/s/cqnzl5/).
+func (op *Operations) Story(ctx context.Context, storyID, opID int64) (time.Duration, error) {
+	duration, _, err := op.readQuery(ctx, measurements.Story, storyQuery, opID, idToShortID(storyID))
+	return duration, err
+}
+
+// Comment ...
+type Comment struct {
+	Ops     *Operations
+	StoryID int64
+	UserID  int64
+	Text    string
+}
+
+// DoOperation ...
+func (op Comment) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Comment(ctx, op.UserID, op.StoryID, op.Text)
+	return measurement(measurements.Comment, measurements.Write, respTime, err)
+}
+
+// CommentStoryID picks the story to be commented, for an operation scheduled
+// at the given offset.
+func (op *Operations) CommentStoryID(r *rand.Rand, at time.Duration) int64 {
+	var storyID int64
+	for storyID == 0 {
+		storyID = sampleStory(op.commentStoryDist, r, at)
 	}
+	return storyID
+}
 
-	// hp := Homepage{}
-	switch op.config.Benchmark.MeasuredSystem {
-	case "proteus":
-		// response := resp.(*pb.QueryResp)
-		// for _, entry := range response.GetRespRecord() {
-		// 	fmt.Println(entry.GetAttributes()["title"], entry.GetAttributes()["short_id"], entry.GetAttributes()["vote_sum"])
-		// }
-		// fmt.Println()
+// sampleStory draws a story from d, with the weights that stories submitted
+// during the run have at the given offset.
+func sampleStory(d distributions.Distribution, r *rand.Rand, at time.Duration) int64 {
+	if dynamic, ok := d.(*distributions.Dynamic); ok {
+		return dynamic.SampleAt(r, at)
 	}
+	return d.Sample(r)
+}
 
-	return duration, nil
+// Comment posts a comment of the given user on the given story.
+func (op *Operations) Comment(ctx context.Context, userID, storyID int64, comment string) (time.Duration, error) {
+	st := time.Now()
+	err := op.system.Comment(ctx, userID, storyID, comment)
+	return time.Since(st), err
 }
 
-// JobFrontPage ...
-type JobFrontPage struct {
-	ops      *Operations
-	queryStr string
-	opID     int64
-	result   *jobFrontPageResult
-	done     chan bool
+// Submit ...
+type Submit struct {
+	Ops         *Operations
+	UserID      int64
+	Description string
+	// the offset the operation is scheduled at
+	At time.Duration
 }
 
-// Do ...
-func (j *JobFrontPage) Do() {
-	j.do()
-	j.done <- true
+// DoOperation ...
+func (op Submit) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Submit(ctx, op.UserID, op.Description, op.At)
+	return measurement(measurements.Submit, measurements.Write, respTime, err)
 }
 
-func (j *JobFrontPage) do() {
-	resp, err := j.ops.qeLobsters.Query(j.queryStr, j.opID)
+// Submit a new story of the given user to the site.
+// at is the offset the operation is scheduled at, from which the weight of
+// the new story in the story distributions decays.
+func (op *Operations) Submit(ctx context.Context, userID int64, description string, at time.Duration) (time.Duration, error) {
+	id := atomic.AddInt64(&op.StoryID, 1)
+
+	st := time.Now()
+	err := op.system.Submit(ctx, id, userID, fmt.Sprintf("story %d", id), description, idToShortID(id))
+	respTime := time.Since(st)
 
-	j.result.resp = resp
-	j.result.err = err
+	if err == nil {
+		for _, d := range op.newStories {
+			d.Add(id, at)
+		}
+	}
+	return respTime, err
 }
 
-type jobFrontPageResult struct {
-	resp interface{}
-	err  error
+// AddUser ...
+func (op *Operations) AddUser(ctx context.Context) error {
+	id := atomic.AddInt64(&op.UserID, 1)
+	return op.system.AddUser(ctx, id, username(id))
 }
 
-// Story ...
-type Story struct {
+// Recent ...
+type Recent struct {
 	Ops *Operations
 }
 
 // DoOperation ...
-func (op Story) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.Story()
+func (op Recent) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Recent(ctx, opID)
+	return measurement(measurements.Recent, measurements.Read, respTime, err)
+}
+
+// Recent renders recently submitted stories (https://lobste.rs/recent).
+func (op *Operations) Recent(ctx context.Context, opID int64) (time.Duration, error) {
+	duration, resp, err := op.readQuery(ctx, measurements.Recent, op.queries.recent, opID)
 	if err != nil {
-		er(err)
+		return duration, err
 	}
-	return measurements.Read, respTime, time.Now()
-}
 
-// Story renders a particular stor based a given shortID (https://lobste.rs/s/cqnzl5/).
-func (op *Operations) Story() (time.Duration, error) {
-	var storyID int64
-	for storyID == 0 {
-		storyID = op.storyVoteSampler.Sample()
+	userIDs := columnValues(resp, "user_id")
+	if len(userIDs) == 0 {
+		return duration, nil
 	}
-	shortID := idToShortID(storyID)
 
-	queryStr := fmt.Sprintf("SELECT title, description, short_id, user_id, vote_sum FROM stories WHERE short_id = '%s'", shortID)
+	// IN lists vary in length, so they can not be prepared; the IDs come
+	// from the datastore, so there is nothing to escape
+	queryStr := fmt.Sprintf("SELECT id, username FROM users WHERE id IN (%s)", strings.Join(userIDs, ", "))
+	respTime, _, err := op.readQuery(ctx, measurements.Recent, queryStr, opID)
+	return duration + respTime, err
+}
 
-	var duration time.Duration
-	st := time.Now()
-	_, err := op.qeProteus.Query(queryStr, 0)
-	duration = time.Since(st)
+// Comments ...
+type Comments struct {
+	Ops *Operations
+}
+
+// DoOperation ...
+func (op Comments) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Comments(ctx, opID)
+	return measurement(measurements.Comments, measurements.Read, respTime, err)
+}
+
+// Comments renders recently submitted comments (https://lobste.rs/comments).
+func (op *Operations) Comments(ctx context.Context, opID int64) (time.Duration, error) {
+	duration, resp, err := op.readQuery(ctx, measurements.Comments, op.queries.comments, opID)
 	if err != nil {
 		return duration, err
 	}
 
-	switch op.config.Benchmark.MeasuredSystem {
-	case "proteus":
-	case "mysql_plain":
-	case "mysql_mv":
+	storyIDs := columnValues(resp, "story_id")
+	userIDs := columnValues(resp, "user_id")
+	if len(storyIDs) == 0 {
+		return duration, nil
+	}
+
+	queryStr := fmt.Sprintf("SELECT id, title, short_id FROM stories WHERE id IN (%s)", strings.Join(storyIDs, ", "))
+	respTime, _, err := op.readQuery(ctx, measurements.Comments, queryStr, opID)
+	duration += respTime
+	if err != nil {
+		return duration, err
 	}
 
-	return duration, nil
+	queryStr = fmt.Sprintf("SELECT id, username FROM users WHERE id IN (%s)", strings.Join(userIDs, ", "))
+	respTime, _, err = op.readQuery(ctx, measurements.Comments, queryStr, opID)
+	return duration + respTime, err
 }
 
-// Comment ...
-type Comment struct {
-	Ops *Operations
+// User ...
+type User struct {
+	Ops    *Operations
+	UserID int64
 }
 
 // DoOperation ...
-func (op Comment) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.Comment()
+func (op User) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.User(ctx, op.UserID, opID)
+	return measurement(measurements.User, measurements.Read, respTime, err)
+}
+
+// User renders a user's profile (https://lobste.rs/u/jonhoo).
+func (op *Operations) User(ctx context.Context, userID, opID int64) (time.Duration, error) {
+	duration, resp, err := op.readQuery(ctx, measurements.User, userQuery, opID, username(userID))
 	if err != nil {
-		er(err)
+		return duration, err
 	}
-	return measurements.Write, respTime, time.Now()
-}
 
-// Comment ...
-func (op *Operations) Comment() (time.Duration, error) {
-	var storyID int64
-	for storyID == 0 {
-		storyID = op.commentStorySampler.Sample()
+	userIDs := columnValues(resp, "id")
+	if len(userIDs) == 0 {
+		return duration, nil
 	}
 
-	var duration time.Duration
-	comment, err := randString(20)
+	respTime, _, err := op.readQuery(ctx, measurements.User, userStoriesQuery, opID, userIDs[0])
+	duration += respTime
 	if err != nil {
 		return duration, err
 	}
 
-	st := time.Now()
-	err = op.ds.Comment(1, storyID, comment)
-	return time.Since(st), err
+	respTime, _, err = op.readQuery(ctx, measurements.User, userCommentsQuery, opID, userIDs[0])
+	return duration + respTime, err
 }
 
-// Submit ...
-type Submit struct {
-	Ops *Operations
+// Login ...
+type Login struct {
+	Ops    *Operations
+	UserID int64
 }
 
 // DoOperation ...
-func (op Submit) DoOperation(opID int64) (measurements.OpType, time.Duration, time.Time) {
-	respTime, err := op.Ops.Submit()
+func (op Login) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.Login(ctx, op.UserID, opID)
+	return measurement(measurements.Login, measurements.Write, respTime, err)
+}
+
+// Login logs in a user.
+// As in Lobsters, an account is created the first time an unknown user logs in.
+func (op *Operations) Login(ctx context.Context, userID, opID int64) (time.Duration, error) {
+	name := username(userID)
+	duration, resp, err := op.readQuery(ctx, measurements.Login, loginQuery, opID, name)
 	if err != nil {
-		er(err)
+		return duration, err
+	}
+
+	if len(columnValues(resp, "one")) > 0 {
+		return duration, nil
 	}
-	return measurements.Write, respTime, time.Now()
+
+	st := time.Now()
+	err = op.system.AddUser(ctx, userID, name)
+	return duration + time.Since(st), err
+}
+
+// Logout ...
+type Logout struct {
+	Ops *Operations
 }
 
-// Submit a new story to the site.
-func (op *Operations) Submit() (time.Duration, error) {
-	var duration time.Duration
+// DoOperation ...
+func (op Logout) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	return measurement(measurements.Logout, measurements.Write, op.Ops.Logout(), nil)
+}
 
-	id := atomic.AddInt64(&op.StoryID, 1)
+// Logout logs out a user.
+// Lobsters only resets the session cookie, so there is no query to issue.
+func (op *Operations) Logout() time.Duration {
+	st := time.Now()
+	return time.Since(st)
+}
 
-	description, err := randString(30)
+// EditComment ...
+type EditComment struct {
+	Ops       *Operations
+	CommentID int64
+	Text      string
+}
+
+// DoOperation ...
+func (op EditComment) DoOperation(ctx context.Context, opID int64) measurements.Measurement {
+	respTime, err := op.Ops.EditComment(ctx, op.CommentID, op.Text, opID)
+	return measurement(measurements.EditComment, measurements.Write, respTime, err)
+}
+
+// EditComment updates the text of an existing comment (POST /comments/X).
+func (op *Operations) EditComment(ctx context.Context, commentID int64, comment string, opID int64) (time.Duration, error) {
+	duration, _, err := op.readQuery(ctx, measurements.EditComment, editCommentQuery, opID, commentID)
 	if err != nil {
 		return duration, err
 	}
 
 	st := time.Now()
-	err = op.ds.Submit(1, fmt.Sprintf("story %d", id), description, idToShortID(id))
-	return time.Since(st), err
+	err = op.system.EditComment(ctx, commentID, comment)
+	return duration + time.Since(st), err
 }
 
-// AddUser ...
-func (op *Operations) AddUser() error {
-	userName, err := randString(10)
-	if err != nil {
-		return err
-	}
-	return op.ds.Adduser(userName[:10])
+// readQuery runs a query of the given read page on the measured system.
+func (op *Operations) readQuery(ctx context.Context, page measurements.OpKind, queryStr string, opID int64, args ...interface{}) (time.Duration, interface{}, error) {
+	st := time.Now()
+	resp, err := op.system.Query(ctx, page, queryStr, opID, args...)
+	return time.Since(st), resp, err
 }
 
-// Recent renders recently submitted stories (https://lobste.rs/recent).
-func (op *Operations) recent() {}
+// RandomUser picks one of the preloaded users.
+func (op *Operations) RandomUser(r *rand.Rand) int64 {
+	return r.Int63n(op.config.Preload.RecordCount.Users) + 1
+}
 
-// Comments renders recently submitted comments (https://lobste.rs/recent).
-func (op *Operations) comments() {}
+// ActiveUser picks the user that votes, comments or submits a story,
+// according to the configured user distribution.
+func (op *Operations) ActiveUser(r *rand.Rand) int64 {
+	if op.userSampler == nil {
+		return op.RandomUser(r)
+	}
 
-// User renders a user's profile(https://lobste.rs/u/jonhoo).
-func (op *Operations) user(username string) {}
+	var userID int64
+	for userID == 0 || userID > op.config.Preload.RecordCount.Users {
+		userID = op.userSampler.Sample(r)
+	}
+	return userID
+}
 
-// Login logs in a user.
-func (op *Operations) login() {}
+// Voters picks n distinct users that vote for the same story or comment,
+// according to the configured user distribution.
+// If there are less than n users, all of them are returned.
+// Users outside the user distribution are picked uniformly once the
+// distribution runs out of distinct users.
+func (op *Operations) Voters(r *rand.Rand, n int64) []int64 {
+	users := op.config.Preload.RecordCount.Users
+	if n >= users {
+		voters := make([]int64, users)
+		for i := range voters {
+			voters[i] = int64(i) + 1
+		}
+		return voters
+	}
 
-// Logout logs out a user.
-func (op *Operations) logout() {}
+	voters := make([]int64, 0, n)
+	picked := make(map[int64]bool, n)
+	for attempts := int64(0); int64(len(voters)) < n; attempts++ {
+		userID := op.ActiveUser(r)
+		if attempts > 10*n {
+			userID = op.RandomUser(r)
+		}
+		if !picked[userID] {
+			picked[userID] = true
+			voters = append(voters, userID)
+		}
+	}
+	return voters
+}
 
 // Close ...
 func (op *Operations) Close() {
-	if op.qeProteus != nil {
-		op.qeProteus.Close()
+	op.system.Close()
+	if op.ds.Db != nil {
+		op.ds.Db.Close()
 	}
-	if op.qeLobsters != nil {
-		op.qeLobsters.Close()
+}
+
+// RandString generates the text of stories and comments.
+func RandString(r *rand.Rand, length int) string {
+	b := make([]byte, length)
+	// (*rand.Rand).Read always returns len(b) and a nil error
+	r.Read(b)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+func measurement(opKind measurements.OpKind, opType measurements.OpType, respTime time.Duration, err error) measurements.Measurement {
+	errKind := classifyError(err)
+	if errKind == measurements.ServerError {
+		// counted in the OpErrors of the op kind, the message is only
+		// useful for debugging
+		log.WithFields(log.Fields{"op": opKind, "error": err}).Debug("operation failed")
+	}
+
+	return measurements.Measurement{
+		RespTime: respTime,
+		OpKind:   opKind,
+		OpType:   opType,
+		Error:    errKind,
+		EndTs:    time.Now(),
 	}
 }
 
-func randString(length int) (string, error) {
-	b, err := generateRandomBytes(length)
-	return base64.URLEncoding.EncodeToString(b), err
+// classifyError maps the error an operation returned to the kind of failure
+// it is reported as.
+func classifyError(err error) measurements.ErrorKind {
+	if err == nil {
+		return measurements.NoError
+	}
+
+	if errors.Is(err, queryengine.ErrNotImplemented) {
+		return measurements.NotImplementedError
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		// ER_LOCK_DEADLOCK
+		case 1213:
+			return measurements.DeadlockError
+		// ER_LOCK_WAIT_TIMEOUT
+		case 1205:
+			return measurements.TimeoutError
+		default:
+			return measurements.ServerError
+		}
+	}
+
+	// errors returned by Proteus
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Aborted:
+			return measurements.DeadlockError
+		case codes.DeadlineExceeded:
+			return measurements.TimeoutError
+		case codes.Unavailable:
+			return measurements.ConnectionError
+		case codes.Unimplemented:
+			return measurements.NotImplementedError
+		default:
+			return measurements.ServerError
+		}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return measurements.TimeoutError
+	}
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, driver.ErrBadConn) || netErr != nil {
+		return measurements.ConnectionError
+	}
+
+	// errors that are only distinguishable by their message
+	switch msg := err.Error(); {
+	case strings.Contains(msg, "Deadlock"):
+		return measurements.DeadlockError
+	case strings.Contains(msg, "out of sync"), strings.Contains(msg, "bad connection"):
+		return measurements.ConnectionError
+	default:
+		return measurements.ServerError
+	}
 }
 
-func generateRandomBytes(n int) ([]byte, error) {
-	b := make([]byte, n)
-	_, err := rand.Read(b)
-	if err != nil {
-		return nil, err
+func username(id int64) string {
+	return fmt.Sprintf("user%d", id)
+}
+
+// columnValues extracts the values of the given column from a datastore
+// query response.
+func columnValues(resp interface{}, column string) []string {
+	rows, ok := resp.([]map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	values := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if val, found := row[column]; found && val != nil {
+			values = append(values, fmt.Sprint(val))
+		}
 	}
 
-	return b, nil
+	return values
 }
 
 func idToShortID(id int64) string {
@@ -550,9 +843,3 @@ func idToShortID(id int64) string {
 
 	return string(str)
 }
-
-func er(err error) {
-	fmt.Println(err)
-	//	debug.PrintStack()
-	//	log.Fatal(err)
-}
@@ -0,0 +1,57 @@
+package operations
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	queryengine "github.com/dvasilas/proteus-lobsters-bench/internal/query-engine"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want measurements.ErrorKind
+	}{
+		{"nil", nil, measurements.NoError},
+		{"not implemented", fmt.Errorf("frontpage: %w", queryengine.ErrNotImplemented), measurements.NotImplementedError},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, measurements.DeadlockError},
+		{"wrapped mysql deadlock", fmt.Errorf("vote: %w", &mysql.MySQLError{Number: 1213}), measurements.DeadlockError},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, measurements.TimeoutError},
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, measurements.ServerError},
+		{"proteus aborted", status.Error(codes.Aborted, "aborted"), measurements.DeadlockError},
+		{"proteus deadline exceeded", status.Error(codes.DeadlineExceeded, "deadline"), measurements.TimeoutError},
+		{"proteus unavailable", status.Error(codes.Unavailable, "unavailable"), measurements.ConnectionError},
+		{"proteus unimplemented", status.Error(codes.Unimplemented, "unimplemented"), measurements.NotImplementedError},
+		{"proteus internal", status.Error(codes.Internal, "internal"), measurements.ServerError},
+		{"context deadline", context.DeadlineExceeded, measurements.TimeoutError},
+		{"wrapped context deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), measurements.TimeoutError},
+		{"net timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, measurements.TimeoutError},
+		{"net error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, measurements.ConnectionError},
+		{"invalid connection", mysql.ErrInvalidConn, measurements.ConnectionError},
+		{"bad connection", fmt.Errorf("exec: %w", driver.ErrBadConn), measurements.ConnectionError},
+		{"deadlock message", errors.New("Error 1213: Deadlock found when trying to get lock"), measurements.DeadlockError},
+		{"out of sync message", errors.New("commands out of sync"), measurements.ConnectionError},
+		{"other", errors.New("unexpected response"), measurements.ServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, classifyError(tc.err))
+		})
+	}
+}
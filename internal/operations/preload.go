@@ -0,0 +1,268 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/distributions"
+)
+
+// InsertUsers inserts the users with IDs first to first+count-1, in a single
+// statement.
+func (op *Operations) InsertUsers(ctx context.Context, first, count int64) error {
+	rows := make([][]interface{}, count)
+	for i := range rows {
+		id := first + int64(i)
+		rows[i] = []interface{}{id, username(id)}
+	}
+	return op.ds.BulkInsert(ctx, "users", []string{"id", "username"}, rows)
+}
+
+// InsertStories inserts a story of each of the given users, with the
+// corresponding description, in a single statement.
+// Stories get consecutive IDs starting from first, and the corresponding vote
+// counts from voteSums.
+func (op *Operations) InsertStories(ctx context.Context, first int64, userIDs []int64, descriptions []string, voteSums []int64) error {
+	rows := make([][]interface{}, len(descriptions))
+	for i, description := range descriptions {
+		id := first + int64(i)
+		rows[i] = []interface{}{id, userIDs[i], fmt.Sprintf("story %d", id), description, idToShortID(id), voteSums[i]}
+	}
+	return op.ds.BulkInsert(ctx, "stories", []string{"id", "user_id", "title", "description", "short_id", "vote_sum"}, rows)
+}
+
+// InsertComments inserts a comment on each of the given stories, by the
+// corresponding user, in a single statement.
+func (op *Operations) InsertComments(ctx context.Context, userIDs, storyIDs []int64, comments []string) error {
+	rows := make([][]interface{}, len(storyIDs))
+	for i, storyID := range storyIDs {
+		rows[i] = []interface{}{userIDs[i], storyID, comments[i]}
+	}
+	return op.ds.BulkInsert(ctx, "comments", []string{"user_id", "story_id", "comment"}, rows)
+}
+
+// InsertStoryVotes inserts a vote for each of the given stories, by the
+// corresponding user, in a single statement.
+// Vote counts are not updated, see InsertStories.
+func (op *Operations) InsertStoryVotes(ctx context.Context, userIDs, storyIDs []int64, vote int) error {
+	rows := make([][]interface{}, len(storyIDs))
+	for i, storyID := range storyIDs {
+		rows[i] = []interface{}{storyID, vote, userIDs[i]}
+	}
+	return op.ds.BulkInsert(ctx, "votes", []string{"story_id", "vote", "user_id"}, rows)
+}
+
+const (
+	countUsersQuery      = "SELECT COUNT(*) FROM users"
+	maxUserIDQuery       = "SELECT MAX(id) FROM users"
+	countStoriesQuery    = "SELECT COUNT(*) FROM stories"
+	minStoryIDQuery      = "SELECT MIN(id) FROM stories"
+	maxStoryIDQuery      = "SELECT MAX(id) FROM stories"
+	countCommentsQuery   = "SELECT COUNT(*) FROM comments"
+	countStoryVotesQuery = "SELECT COUNT(*) FROM votes WHERE story_id IS NOT NULL"
+	storyVoteSumsQuery   = "SELECT story_id, SUM(vote) FROM votes WHERE story_id IS NOT NULL GROUP BY story_id"
+	storyCommentsQuery   = "SELECT story_id, COUNT(*) FROM comments GROUP BY story_id"
+	storyVotersQuery     = "SELECT user_id, story_id FROM votes WHERE story_id IS NOT NULL"
+	storyShortIDsQuery   = "SELECT id, short_id FROM stories"
+	wrongVoteSumsQuery   = "SELECT COUNT(*) FROM stories s LEFT JOIN " +
+		"(SELECT story_id, SUM(vote) AS total FROM votes WHERE story_id IS NOT NULL GROUP BY story_id) v " +
+		"ON v.story_id = s.id WHERE s.vote_sum <> COALESCE(v.total, 0)"
+)
+
+// PreloadState is what the datastore holds from previous preloads.
+type PreloadState struct {
+	Users      int64
+	MaxUserID  int64
+	Stories    int64
+	MaxStoryID int64
+	Comments   int64
+	StoryVotes int64
+}
+
+// PreloadState reads the row counts, and the largest user and story IDs, of
+// the datastore.
+func (op *Operations) PreloadState(ctx context.Context) (PreloadState, error) {
+	var s PreloadState
+	for _, q := range []struct {
+		query string
+		dest  *int64
+	}{
+		{countUsersQuery, &s.Users},
+		{maxUserIDQuery, &s.MaxUserID},
+		{countStoriesQuery, &s.Stories},
+		{maxStoryIDQuery, &s.MaxStoryID},
+		{countCommentsQuery, &s.Comments},
+		{countStoryVotesQuery, &s.StoryVotes},
+	} {
+		v, err := op.ds.QueryInt(ctx, q.query)
+		if err != nil {
+			return s, err
+		}
+		*q.dest = v
+	}
+	return s, nil
+}
+
+// VotesPerStory returns the number of votes of each story (indexed by story
+// ID), so that they follow the VotesPerStory histogram, or nil if there is
+// no such histogram.
+func (op *Operations) VotesPerStory() []int64 {
+	if len(op.config.Distributions.VotesPerStory) == 0 {
+		return nil
+	}
+	return distributions.PerID(op.config.Distributions.VotesPerStory, op.config.Preload.RecordCount.Stories)
+}
+
+// CommentsPerStory returns the number of comments of each story (indexed by
+// story ID), so that they follow the CommentsPerStory histogram, or nil if
+// there is no such histogram.
+func (op *Operations) CommentsPerStory() []int64 {
+	if len(op.config.Distributions.CommentsPerStory) == 0 {
+		return nil
+	}
+	return distributions.PerID(op.config.Distributions.CommentsPerStory, op.config.Preload.RecordCount.Stories)
+}
+
+// StoryVoteSums adds up the votes of each story in the datastore into
+// voteSums, which is indexed by story ID.
+// Stories outside voteSums are ignored.
+func (op *Operations) StoryVoteSums(ctx context.Context, voteSums []int64) error {
+	return op.perStory(ctx, storyVoteSumsQuery, voteSums)
+}
+
+// StoryCommentCounts adds up the comments of each story in the datastore
+// into counts, which is indexed by story ID.
+// Stories outside counts are ignored.
+func (op *Operations) StoryCommentCounts(ctx context.Context, counts []int64) error {
+	return op.perStory(ctx, storyCommentsQuery, counts)
+}
+
+// StoryVoters calls fn with the user and story of each story vote in the
+// datastore.
+func (op *Operations) StoryVoters(ctx context.Context, fn func(userID, storyID int64)) error {
+	rows, err := op.ds.Query(ctx, storyVotersQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, storyID int64
+		if err := rows.Scan(&userID, &storyID); err != nil {
+			return err
+		}
+		fn(userID, storyID)
+	}
+	return rows.Err()
+}
+
+// perStory runs a query that returns (story ID, value) rows, and adds the
+// values to dest.
+func (op *Operations) perStory(ctx context.Context, query string, dest []int64) error {
+	rows, err := op.ds.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var storyID, v int64
+		if err := rows.Scan(&storyID, &v); err != nil {
+			return err
+		}
+		if storyID >= 0 && storyID < int64(len(dest)) {
+			dest[storyID] += v
+		}
+	}
+	return rows.Err()
+}
+
+// VerifyPreload checks that the datastore matches the preload configuration:
+// row counts, story IDs from 1 to the number of stories with their
+// corresponding short IDs, and vote counts.
+// It prints the outcome of each check, and returns an error if any fails.
+func (op *Operations) VerifyPreload(ctx context.Context) error {
+	state, err := op.PreloadState(ctx)
+	if err != nil {
+		return err
+	}
+	minStoryID, err := op.ds.QueryInt(ctx, minStoryIDQuery)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	check := func(ok bool, format string, a ...interface{}) {
+		status := "ok"
+		if !ok {
+			status = "FAILED"
+			failed = true
+		}
+		fmt.Printf("[verify] %s: %s\n", fmt.Sprintf(format, a...), status)
+	}
+
+	rc := op.config.Preload.RecordCount
+	comments, votes := rc.Comments, rc.Votes
+	if perStory := op.CommentsPerStory(); perStory != nil {
+		comments = sum(perStory)
+	}
+	if perStory := op.VotesPerStory(); perStory != nil {
+		votes = sum(perStory)
+	}
+	check(state.Users == rc.Users, "users: %d (expected %d)", state.Users, rc.Users)
+	check(state.Stories == rc.Stories, "stories: %d (expected %d)", state.Stories, rc.Stories)
+	check(state.Comments == comments, "comments: %d (expected %d)", state.Comments, comments)
+	check(state.StoryVotes == votes, "story votes: %d (expected %d)", state.StoryVotes, votes)
+
+	contiguous := state.Stories == 0 || (minStoryID == 1 && state.MaxStoryID == state.Stories)
+	check(contiguous, "story IDs: %d to %d", minStoryID, state.MaxStoryID)
+
+	wrongShortIDs, err := op.wrongShortIDs(ctx)
+	if err != nil {
+		return err
+	}
+	check(wrongShortIDs == 0, "stories with a wrong short ID: %d", wrongShortIDs)
+
+	wrongVoteSums, err := op.ds.QueryInt(ctx, wrongVoteSumsQuery)
+	if err != nil {
+		return err
+	}
+	check(wrongVoteSums == 0, "stories with a wrong vote count: %d", wrongVoteSums)
+
+	if failed {
+		return errors.New("dataset verification failed")
+	}
+	return nil
+}
+
+// wrongShortIDs counts the stories whose short ID does not correspond to
+// their ID.
+func (op *Operations) wrongShortIDs(ctx context.Context) (int64, error) {
+	rows, err := op.ds.Query(ctx, storyShortIDsQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var wrong int64
+	for rows.Next() {
+		var id int64
+		var shortID string
+		if err := rows.Scan(&id, &shortID); err != nil {
+			return 0, err
+		}
+		if shortID != idToShortID(id) {
+			wrong++
+		}
+	}
+	return wrong, rows.Err()
+}
+
+func sum(values []int64) int64 {
+	var total int64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,431 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/datastore"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	queryengine "github.com/dvasilas/proteus-lobsters-bench/internal/query-engine"
+	workerpool "github.com/dvasilas/proteus-lobsters-bench/internal/worker_pool"
+)
+
+// System is the driver of a measured system.
+// It issues the Lobsters read and write operations to the system; Operations
+// picks their targets and measures their response time.
+type System interface {
+	// Query runs a query of the given read page (Frontpage, Story, ..).
+	// Query arguments replace the query's ? placeholders.
+	Query(ctx context.Context, page measurements.OpKind, query string, opID int64, args ...interface{}) (interface{}, error)
+	StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) error
+	CommentVote(ctx context.Context, userID, commentID int64, vote int, opID int64) error
+	Comment(ctx context.Context, userID, storyID int64, comment string) error
+	Submit(ctx context.Context, id, userID int64, title, description, shortID string) error
+	EditComment(ctx context.Context, commentID int64, comment string) error
+	AddUser(ctx context.Context, id int64, username string) error
+	// Close closes the connections of the driver; the datastore is closed
+	// by Operations.
+	Close()
+}
+
+// NewSystemFunc creates the driver of a measured system.
+// ds is the Lobsters datastore, which has no connection if no DBEndpoint is
+// configured.
+type NewSystemFunc func(conf *config.BenchmarkConfig, ds *datastore.Datastore) (System, error)
+
+var systems = make(map[string]NewSystemFunc)
+
+func init() {
+	RegisterSystem("proteus", newProteusSystem)
+	RegisterSystem("mysql", newMysqlSystem)
+	RegisterSystem("baseline", newBaselineSystem)
+	RegisterSystem("baseline_workers", newBaselineWorkersSystem)
+}
+
+// RegisterSystem makes a measured system available by name, for the
+// Benchmark.MeasuredSystem configuration option.
+// It panics if a system is registered twice.
+func RegisterSystem(name string, newSystem NewSystemFunc) {
+	if _, found := systems[name]; found {
+		panic("system registered twice: " + name)
+	}
+	systems[name] = newSystem
+}
+
+// Systems returns the names of the registered systems, sorted.
+func Systems() []string {
+	names := make([]string, 0, len(systems))
+	for name := range systems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupSystem(name string) (NewSystemFunc, error) {
+	newSystem, found := systems[name]
+	if !found {
+		return nil, fmt.Errorf("unknown system %q (available: %s)", name, strings.Join(Systems(), ", "))
+	}
+	return newSystem, nil
+}
+
+// ------------------ Baseline ---------------
+
+// baselineSystem runs all operations directly against the datastore.
+// The other drivers embed it for the operations they do not serve.
+type baselineSystem struct {
+	ds *datastore.Datastore
+	qe queryengine.QueryEngine
+}
+
+func newBaselineSystem(conf *config.BenchmarkConfig, ds *datastore.Datastore) (System, error) {
+	if err := requireDatastore("baseline", ds); err != nil {
+		return nil, err
+	}
+	return newDatastoreSystem(ds), nil
+}
+
+// requireDatastore returns an error if the datastore, which the given system
+// runs (some of) its operations against, has no connection.
+func requireDatastore(system string, ds *datastore.Datastore) error {
+	if ds.Db == nil {
+		return fmt.Errorf("system %q needs the datastore, but Connection.DBEndpoint is not set", system)
+	}
+	return nil
+}
+
+func newDatastoreSystem(ds *datastore.Datastore) baselineSystem {
+	return baselineSystem{
+		ds: ds,
+		qe: queryengine.NewBaselineQE(ds),
+	}
+}
+
+// Query ...
+func (s baselineSystem) Query(ctx context.Context, page measurements.OpKind, query string, opID int64, args ...interface{}) (interface{}, error) {
+	return s.qe.Query(ctx, query, opID, args...)
+}
+
+// StoryVote ...
+// The vote count is updated in the same transaction as the vote insertion.
+func (s baselineSystem) StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) error {
+	return s.ds.StoryVoteUpdateCount(ctx, userID, storyID, vote)
+}
+
+// CommentVote ...
+func (s baselineSystem) CommentVote(ctx context.Context, userID, commentID int64, vote int, opID int64) error {
+	return s.ds.CommentVoteUpdateCount(ctx, userID, commentID, vote)
+}
+
+// Comment ...
+func (s baselineSystem) Comment(ctx context.Context, userID, storyID int64, comment string) error {
+	return s.ds.Comment(ctx, userID, storyID, comment)
+}
+
+// Submit ...
+func (s baselineSystem) Submit(ctx context.Context, id, userID int64, title, description, shortID string) error {
+	return s.ds.Submit(ctx, id, userID, title, description, shortID)
+}
+
+// EditComment ...
+func (s baselineSystem) EditComment(ctx context.Context, commentID int64, comment string) error {
+	return s.ds.EditComment(ctx, commentID, comment)
+}
+
+// AddUser ...
+func (s baselineSystem) AddUser(ctx context.Context, id int64, username string) error {
+	return s.ds.Adduser(ctx, id, username)
+}
+
+// Close ...
+func (s baselineSystem) Close() {}
+
+// ------------------ Baseline with worker pools ---------------
+
+// baselineWorkersSystem is the baseline, with reads and votes executed by
+// bounded worker pools.
+type baselineWorkersSystem struct {
+	baselineSystem
+	dispatcherQ *workerpool.Dispatcher
+	dispatcherW *workerpool.Dispatcher
+}
+
+func newBaselineWorkersSystem(conf *config.BenchmarkConfig, ds *datastore.Datastore) (System, error) {
+	if err := requireDatastore("baseline_workers", ds); err != nil {
+		return nil, err
+	}
+	s := baselineWorkersSystem{
+		baselineSystem: newDatastoreSystem(ds),
+		dispatcherQ:    workerpool.NewDispatcher(int(conf.WorkerPoolSizeQ), int(conf.JobQueueSizeQ)),
+		dispatcherW:    workerpool.NewDispatcher(int(conf.WorkerPoolSizeW), int(conf.JobQueueSizeW)),
+	}
+	s.dispatcherQ.Run()
+	s.dispatcherW.Run()
+	return s, nil
+}
+
+// Query ...
+func (s baselineWorkersSystem) Query(ctx context.Context, page measurements.OpKind, query string, opID int64, args ...interface{}) (interface{}, error) {
+	work := &JobQuery{
+		ctx:      ctx,
+		qe:       s.qe,
+		queryStr: query,
+		args:     args,
+		opID:     opID,
+		result:   &jobQueryResult{},
+		done:     make(chan bool, 1),
+	}
+
+	if err := runJob(ctx, s.dispatcherQ, work, work.done); err != nil {
+		return nil, err
+	}
+	return work.result.resp, work.result.err
+}
+
+// StoryVote ...
+func (s baselineWorkersSystem) StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) error {
+	work := &JobStoryVote{
+		ctx:     ctx,
+		ds:      s.ds,
+		userID:  userID,
+		storyID: storyID,
+		vote:    vote,
+		result:  &jobStoryVoteResult{},
+		done:    make(chan bool, 1),
+	}
+
+	if err := runJob(ctx, s.dispatcherW, work, work.done); err != nil {
+		return err
+	}
+	return work.result.err
+}
+
+// CommentVote ...
+func (s baselineWorkersSystem) CommentVote(ctx context.Context, userID, commentID int64, vote int, opID int64) error {
+	work := &JobCommentVote{
+		ctx:       ctx,
+		ds:        s.ds,
+		userID:    userID,
+		commentID: commentID,
+		vote:      vote,
+		result:    &jobCommentVoteResult{},
+		done:      make(chan bool, 1),
+	}
+
+	if err := runJob(ctx, s.dispatcherW, work, work.done); err != nil {
+		return err
+	}
+	return work.result.err
+}
+
+// ------------------ Proteus ---------------
+
+// proteusSystem serves the frontpage and the story page from Proteus views,
+// and story votes through the Lobsters Proteus endpoints.
+// Proteus maintains the comment vote count, so comment votes only insert
+// the vote.
+type proteusSystem struct {
+	baselineSystem
+	qeProteus  queryengine.QueryEngine
+	qeLobsters queryengine.QueryEngine
+}
+
+func newProteusSystem(conf *config.BenchmarkConfig, ds *datastore.Datastore) (System, error) {
+	// the pages and comment votes without a Proteus endpoint go to the
+	// datastore
+	if err := requireDatastore("proteus", ds); err != nil {
+		return nil, err
+	}
+
+	s := proteusSystem{baselineSystem: newDatastoreSystem(ds)}
+	var err error
+	// the views are only needed for reads
+	if conf.Operations.WriteRatio < 1.0 {
+		s.qeProteus, err = queryengine.NewProteusQE(conf.Connection.ProteusEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.qeLobsters, err = queryengine.NewProteusQE(conf.Connection.LobstersEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Query ...
+func (s proteusSystem) Query(ctx context.Context, page measurements.OpKind, query string, opID int64, args ...interface{}) (interface{}, error) {
+	switch page {
+	case measurements.Frontpage, measurements.Story:
+		if s.qeProteus == nil {
+			return nil, queryengine.ErrNotImplemented
+		}
+		return s.qeProteus.Query(ctx, query, opID, args...)
+	default:
+		return s.baselineSystem.Query(ctx, page, query, opID, args...)
+	}
+}
+
+// StoryVote ...
+func (s proteusSystem) StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) error {
+	return s.qeLobsters.StoryVote(ctx, storyID, vote, opID)
+}
+
+// CommentVote ...
+func (s proteusSystem) CommentVote(ctx context.Context, userID, commentID int64, vote int, opID int64) error {
+	return s.ds.CommentVoteSimple(ctx, userID, commentID, vote)
+}
+
+// Close ...
+func (s proteusSystem) Close() {
+	if s.qeProteus != nil {
+		s.qeProteus.Close()
+	}
+	s.qeLobsters.Close()
+}
+
+// ------------------ MySQL ---------------
+
+// mysqlSystem serves the frontpage and story votes through the Lobsters
+// MySQL server.
+// There is no endpoint for the other operations, so they are run against
+// the datastore, as in the baseline.
+type mysqlSystem struct {
+	baselineSystem
+	qe queryengine.QueryEngine
+}
+
+func newMysqlSystem(conf *config.BenchmarkConfig, ds *datastore.Datastore) (System, error) {
+	if err := requireDatastore("mysql", ds); err != nil {
+		return nil, err
+	}
+	qe, err := queryengine.NewMysqlQE(conf.Connection.ProteusEndpoints, conf.Connection.PoolSize, conf.Connection.PoolOverflow, conf.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	return mysqlSystem{
+		baselineSystem: newDatastoreSystem(ds),
+		qe:             qe,
+	}, nil
+}
+
+// Query ...
+func (s mysqlSystem) Query(ctx context.Context, page measurements.OpKind, query string, opID int64, args ...interface{}) (interface{}, error) {
+	if page == measurements.Frontpage {
+		return s.qe.Query(ctx, query, opID, args...)
+	}
+	return s.baselineSystem.Query(ctx, page, query, opID, args...)
+}
+
+// StoryVote ...
+func (s mysqlSystem) StoryVote(ctx context.Context, userID, storyID int64, vote int, opID int64) error {
+	return s.qe.StoryVote(ctx, storyID, vote, opID)
+}
+
+// Close ...
+func (s mysqlSystem) Close() {
+	s.qe.Close()
+}
+
+// ------------------ Worker pool jobs ---------------
+
+// JobQuery ...
+type JobQuery struct {
+	ctx      context.Context
+	qe       queryengine.QueryEngine
+	queryStr string
+	args     []interface{}
+	opID     int64
+	result   *jobQueryResult
+	done     chan bool
+}
+
+// Do ...
+func (j *JobQuery) Do() {
+	j.do()
+	j.done <- true
+}
+
+func (j *JobQuery) do() {
+	resp, err := j.qe.Query(j.ctx, j.queryStr, j.opID, j.args...)
+
+	j.result.resp = resp
+	j.result.err = err
+}
+
+type jobQueryResult struct {
+	resp interface{}
+	err  error
+}
+
+// JobStoryVote ...
+type JobStoryVote struct {
+	ctx     context.Context
+	ds      *datastore.Datastore
+	result  *jobStoryVoteResult
+	userID  int64
+	storyID int64
+	vote    int
+	done    chan bool
+}
+
+// Do ...
+func (j *JobStoryVote) Do() {
+	j.do()
+	j.done <- true
+}
+
+func (j *JobStoryVote) do() {
+	j.result.err = j.ds.StoryVoteUpdateCount(j.ctx, j.userID, j.storyID, j.vote)
+}
+
+type jobStoryVoteResult struct {
+	err error
+}
+
+// JobCommentVote ...
+type JobCommentVote struct {
+	ctx       context.Context
+	ds        *datastore.Datastore
+	result    *jobCommentVoteResult
+	userID    int64
+	commentID int64
+	vote      int
+	done      chan bool
+}
+
+// Do ...
+func (j *JobCommentVote) Do() {
+	j.do()
+	j.done <- true
+}
+
+func (j *JobCommentVote) do() {
+	j.result.err = j.ds.CommentVoteUpdateCount(j.ctx, j.userID, j.commentID, j.vote)
+}
+
+type jobCommentVoteResult struct {
+	err error
+}
+
+// runJob submits a job to the worker pool, and waits for it to signal done.
+// It stops waiting if ctx is done; done needs to be buffered so that the
+// worker does not block on an abandoned job.
+func runJob(ctx context.Context, dispatcher *workerpool.Dispatcher, job workerpool.Job, done chan bool) error {
+	select {
+	case dispatcher.JobQueue <- job:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
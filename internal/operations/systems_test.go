@@ -0,0 +1,23 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemsRequireDatastore(t *testing.T) {
+	for _, system := range []string{"baseline", "baseline_workers", "mysql", "proteus"} {
+		t.Run(system, func(t *testing.T) {
+			conf := &config.BenchmarkConfig{}
+			conf.Benchmark.MeasuredSystem = system
+			conf.Operations.DistributionType = "uniform"
+
+			_, err := NewOperations(conf)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "DBEndpoint")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package queryengine
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -12,10 +13,16 @@ import (
 	proteusclient "github.com/dvasilas/proteus/pkg/proteus-go-client"
 )
 
+// ErrNotImplemented is returned for operations the measured system does not
+// support.
+var ErrNotImplemented = errors.New("operation not implemented by the measured system")
+
 // QueryEngine ...
+// Query and StoryVote return ctx.Err() as soon as ctx is done.
+// Query arguments replace the query's ? placeholders.
 type QueryEngine interface {
-	Query(query string, opID int64) (interface{}, error)
-	StoryVote(storyID int64, vote int, opID int64) error
+	Query(ctx context.Context, query string, opID int64, args ...interface{}) (interface{}, error)
+	StoryVote(ctx context.Context, storyID int64, vote int, opID int64) error
 	Close()
 }
 
@@ -79,13 +86,21 @@ func NewProteusQE(endpoints []string, poolSize, poolOverflow int, tracing bool)
 }
 
 // Query ...
-func (qe ProteusQE) Query(query string, opID int64) (resp interface{}, err error) {
-	return qe.proteusClient[int(opID%int64(qe.serverCount))].Query(query)
+// Proteus does not support query parameters, so arguments are interpolated.
+func (qe ProteusQE) Query(ctx context.Context, query string, opID int64, args ...interface{}) (resp interface{}, err error) {
+	if len(args) > 0 {
+		query = interpolate(query, args)
+	}
+	return withContext(ctx, func() (interface{}, error) {
+		return qe.proteusClient[int(opID%int64(qe.serverCount))].Query(query)
+	})
 }
 
 // StoryVote ...
-func (qe ProteusQE) StoryVote(storyID int64, vote int, opID int64) error {
-	_, err := qe.proteusClient[int(opID%int64(qe.serverCount))].LobstersStoryVoteInsert(storyID, vote)
+func (qe ProteusQE) StoryVote(ctx context.Context, storyID int64, vote int, opID int64) error {
+	_, err := withContext(ctx, func() (interface{}, error) {
+		return qe.proteusClient[int(opID%int64(qe.serverCount))].LobstersStoryVoteInsert(storyID, vote)
+	})
 	return err
 }
 
@@ -137,16 +152,17 @@ func NewMysqlQE(endpoints []string, poolSize, poolOverflow int, tracing bool) (M
 }
 
 // Query ...
-func (qe MysqlQE) Query(query string, opID int64) (interface{}, error) {
-	resp, err := qe.proteusClient.LobstersFrontpage()
-	fmt.Println("LobstersFrontpage", resp, err)
-	return resp, err
+func (qe MysqlQE) Query(ctx context.Context, query string, opID int64, args ...interface{}) (interface{}, error) {
+	return withContext(ctx, func() (interface{}, error) {
+		return qe.proteusClient.LobstersFrontpage()
+	})
 }
 
 // StoryVote ...
-func (qe MysqlQE) StoryVote(storyID int64, vote int, opID int64) error {
-	_, err := qe.proteusClient.LobstersStoryVote(storyID, vote)
-	fmt.Println("StoryVote", err)
+func (qe MysqlQE) StoryVote(ctx context.Context, storyID int64, vote int, opID int64) error {
+	_, err := withContext(ctx, func() (interface{}, error) {
+		return qe.proteusClient.LobstersStoryVote(storyID, vote)
+	})
 	return err
 }
 
@@ -170,8 +186,8 @@ func NewBaselineQE(ds *datastore.Datastore) BaselineQE {
 }
 
 // Query ...
-func (qe BaselineQE) Query(query string, opID int64) (interface{}, error) {
-	rows, err := qe.ds.Db.Query(query)
+func (qe BaselineQE) Query(ctx context.Context, query string, opID int64, args ...interface{}) (interface{}, error) {
+	rows, err := qe.ds.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -219,11 +235,60 @@ func (qe BaselineQE) Query(query string, opID int64) (interface{}, error) {
 }
 
 // StoryVote ...
-func (qe BaselineQE) StoryVote(storyID int64, vote int, opID int64) error {
-	return nil
+// The baseline updates the vote count through the datastore directly.
+func (qe BaselineQE) StoryVote(ctx context.Context, storyID int64, vote int, opID int64) error {
+	return ErrNotImplemented
 }
 
 // Close ...
 func (qe BaselineQE) Close() {
 	qe.ds.Db.Close()
 }
+
+// withContext runs f, but returns as soon as ctx is done.
+// The Proteus client does not take a context, so the request itself is left
+// to complete in the background.
+func withContext(ctx context.Context, f func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		resp, err := f()
+		resultCh <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// interpolate replaces the ? placeholders of query with the given arguments.
+func interpolate(query string, args []interface{}) string {
+	var b strings.Builder
+	for _, arg := range args {
+		i := strings.IndexByte(query, '?')
+		if i < 0 {
+			break
+		}
+		b.WriteString(query[:i])
+		switch a := arg.(type) {
+		case string:
+			b.WriteString("'" + strings.ReplaceAll(a, "'", "''") + "'")
+		default:
+			fmt.Fprint(&b, a)
+		}
+		query = query[i+1:]
+	}
+	b.WriteString(query)
+
+	return b.String()
+}
@@ -0,0 +1,260 @@
+package results
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	getmetrics "github.com/dvasilas/proteus-lobsters-bench/internal/getMetrics"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+)
+
+// SchemaVersion is the version of the results document layout.
+// It needs to be incremented whenever a field is renamed or removed.
+const SchemaVersion = 3
+
+// Results is the machine-readable outcome of a benchmark run.
+type Results struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	Config        config.BenchmarkConfig `json:"config"`
+	Metrics       Metrics                `json:"metrics"`
+	TotalMetrics  OpMetrics              `json:"totalMetrics"`
+	ReadMetrics   OpMetrics              `json:"readMetrics"`
+	WriteMetrics  OpMetrics              `json:"writeMetrics"`
+	PerOpMetrics  map[string]OpMetrics   `json:"perOpMetrics"`
+	QPUMetrics    []QPUMetrics           `json:"qpuMetrics"`
+}
+
+// Metrics ...
+type Metrics struct {
+	RuntimeSeconds float64 `json:"runtimeSeconds"`
+	LoadOffered    float64 `json:"loadOffered"`
+	Throughput     float64 `json:"throughput"`
+	// how late open-loop operations were sent compared to their schedule
+	MeanScheduleLag float64 `json:"meanScheduleLagMs"`
+	MaxScheduleLag  float64 `json:"maxScheduleLagMs"`
+}
+
+// OpMetrics ...
+type OpMetrics struct {
+	OpCount    int64   `json:"opCount"`
+	Throughput float64 `json:"throughput"`
+	P50        float64 `json:"p50Ms"`
+	P90        float64 `json:"p90Ms"`
+	P95        float64 `json:"p95Ms"`
+	P99        float64 `json:"p99Ms"`
+	// latency measured from the scheduled send time
+	CorrectedP50 float64 `json:"correctedP50Ms"`
+	CorrectedP90 float64 `json:"correctedP90Ms"`
+	CorrectedP95 float64 `json:"correctedP95Ms"`
+	CorrectedP99 float64 `json:"correctedP99Ms"`
+	// failed operations, and the time they took to fail
+	ErrorCount int64            `json:"errorCount"`
+	Errors     map[string]int64 `json:"errors"`
+	ErrorP50   float64          `json:"errorP50Ms"`
+	ErrorP90   float64          `json:"errorP90Ms"`
+	ErrorP95   float64          `json:"errorP95Ms"`
+	ErrorP99   float64          `json:"errorP99Ms"`
+}
+
+// QPUMetrics ...
+type QPUMetrics struct {
+	Name                   string  `json:"name"`
+	NotificationLatencyP50 float64 `json:"notificationLatencyP50Ms"`
+	NotificationLatencyP90 float64 `json:"notificationLatencyP90Ms"`
+	NotificationLatencyP95 float64 `json:"notificationLatencyP95Ms"`
+	NotificationLatencyP99 float64 `json:"notificationLatencyP99Ms"`
+	ProcessingLatencyP50   float64 `json:"processingLatencyP50Ms"`
+	ProcessingLatencyP90   float64 `json:"processingLatencyP90Ms"`
+	ProcessingLatencyP95   float64 `json:"processingLatencyP95Ms"`
+	ProcessingLatencyP99   float64 `json:"processingLatencyP99Ms"`
+	StateUpdateLatencyP50  float64 `json:"stateUpdateLatencyP50Ms"`
+	StateUpdateLatencyP90  float64 `json:"stateUpdateLatencyP90Ms"`
+	StateUpdateLatencyP95  float64 `json:"stateUpdateLatencyP95Ms"`
+	StateUpdateLatencyP99  float64 `json:"stateUpdateLatencyP99Ms"`
+	FreshnessLatencyP50    float64 `json:"freshnessLatencyP50Ms"`
+	FreshnessLatencyP90    float64 `json:"freshnessLatencyP90Ms"`
+	FreshnessLatencyP95    float64 `json:"freshnessLatencyP95Ms"`
+	FreshnessLatencyP99    float64 `json:"freshnessLatencyP99Ms"`
+	FreshnessVersions0     float64 `json:"freshnessVersions0"`
+	FreshnessVersions1     float64 `json:"freshnessVersions1"`
+	FreshnessVersions2     float64 `json:"freshnessVersions2"`
+	FreshnessVersions4     float64 `json:"freshnessVersions4"`
+	KBytesSent             float64 `json:"kBytesSent"`
+	ResponseTimeP50        float64 `json:"responseTimeP50Ms"`
+	ResponseTimeP90        float64 `json:"responseTimeP90Ms"`
+	ResponseTimeP95        float64 `json:"responseTimeP95Ms"`
+	ResponseTimeP99        float64 `json:"responseTimeP99Ms"`
+}
+
+// New ...
+func New(conf config.BenchmarkConfig, metrics measurements.Metrics, qpuMetrics []getmetrics.QPUMetrics) Results {
+	r := Results{
+		SchemaVersion: SchemaVersion,
+		Config:        conf,
+		Metrics: Metrics{
+			RuntimeSeconds:  metrics.Runtime.Seconds(),
+			LoadOffered:     metrics.LoadOffered,
+			Throughput:      metrics.Throughput,
+			MeanScheduleLag: float64(metrics.ScheduleLag.Mean()) / float64(time.Millisecond),
+			MaxScheduleLag:  float64(metrics.ScheduleLag.Max) / float64(time.Millisecond),
+		},
+		TotalMetrics: newOpMetrics(metrics.TotalMetrics),
+		ReadMetrics:  newOpMetrics(metrics.ReadMetrics),
+		WriteMetrics: newOpMetrics(metrics.WriteMetrics),
+		PerOpMetrics: make(map[string]OpMetrics),
+		QPUMetrics:   make([]QPUMetrics, len(qpuMetrics)),
+	}
+
+	for opKind, m := range metrics.PerOpMetrics {
+		r.PerOpMetrics[opKind] = newOpMetrics(m)
+	}
+
+	for i, q := range qpuMetrics {
+		m := q.Metrics
+		r.QPUMetrics[i] = QPUMetrics{
+			Name:                   q.Name,
+			NotificationLatencyP50: m.NotificationLatencyP50,
+			NotificationLatencyP90: m.NotificationLatencyP90,
+			NotificationLatencyP95: m.NotificationLatencyP95,
+			NotificationLatencyP99: m.NotificationLatencyP99,
+			ProcessingLatencyP50:   m.ProcessingLatencyP50,
+			ProcessingLatencyP90:   m.ProcessingLatencyP90,
+			ProcessingLatencyP95:   m.ProcessingLatencyP95,
+			ProcessingLatencyP99:   m.ProcessingLatencyP99,
+			StateUpdateLatencyP50:  m.StateUpdateLatencyP50,
+			StateUpdateLatencyP90:  m.StateUpdateLatencyP90,
+			StateUpdateLatencyP95:  m.StateUpdateLatencyP95,
+			StateUpdateLatencyP99:  m.StateUpdateLatencyP99,
+			FreshnessLatencyP50:    m.FreshnessLatencyP50,
+			FreshnessLatencyP90:    m.FreshnessLatencyP90,
+			FreshnessLatencyP95:    m.FreshnessLatencyP95,
+			FreshnessLatencyP99:    m.FreshnessLatencyP99,
+			FreshnessVersions0:     m.FreshnessVersions0,
+			FreshnessVersions1:     m.FreshnessVersions1,
+			FreshnessVersions2:     m.FreshnessVersions2,
+			FreshnessVersions4:     m.FreshnessVersions4,
+			KBytesSent:             m.KBytesSent,
+			ResponseTimeP50:        m.ResponseTimeP50,
+			ResponseTimeP90:        m.ResponseTimeP90,
+			ResponseTimeP95:        m.ResponseTimeP95,
+			ResponseTimeP99:        m.ResponseTimeP99,
+		}
+	}
+
+	return r
+}
+
+func newOpMetrics(m measurements.OpMetrics) OpMetrics {
+	return OpMetrics{
+		OpCount:      m.OpCount,
+		Throughput:   m.Throughput,
+		P50:          m.P50,
+		P90:          m.P90,
+		P95:          m.P95,
+		P99:          m.P99,
+		CorrectedP50: m.CorrectedP50,
+		CorrectedP90: m.CorrectedP90,
+		CorrectedP95: m.CorrectedP95,
+		CorrectedP99: m.CorrectedP99,
+		ErrorCount:   m.ErrorCount,
+		Errors:       m.Errors,
+		ErrorP50:     m.ErrorP50,
+		ErrorP90:     m.ErrorP90,
+		ErrorP95:     m.ErrorP95,
+		ErrorP99:     m.ErrorP99,
+	}
+}
+
+// Write writes the results to f in the given format ("json" or "csv").
+func (r Results) Write(f *os.File, format string) error {
+	switch format {
+	case "json":
+		return r.writeJSON(f)
+	case "csv":
+		return r.writeCSV(f)
+	default:
+		return errors.New("unknown results format")
+	}
+}
+
+func (r Results) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeCSV flattens the JSON document into (key, value) rows.
+// Keys are the dot-separated JSON paths (array elements are indexed), so the
+// CSV output follows the same schema as the JSON one.
+func (r Results) writeCSV(w io.Writer) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"key", "value"}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	if err := flatten(dec, "", cw); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func flatten(dec *json.Decoder, prefix string, cw *csv.Writer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := flatten(dec, joinKey(prefix, keyTok.(string)), cw); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for i := 0; dec.More(); i++ {
+				if err := flatten(dec, prefix+"["+strconv.Itoa(i)+"]", cw); err != nil {
+					return err
+				}
+			}
+		}
+		// consume the closing delimiter
+		_, err := dec.Token()
+		return err
+	case nil:
+		return cw.Write([]string{prefix, ""})
+	default:
+		return cw.Write([]string{prefix, fmt.Sprint(t)})
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.Join([]string{prefix, key}, ".")
+}
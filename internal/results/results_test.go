@@ -0,0 +1,34 @@
+package results
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJSONConfig(t *testing.T) {
+	conf := config.BenchmarkConfig{}
+	conf.Benchmark.MeasuredSystem = "mysql"
+	conf.Connection.DBEndpoint = "db:3306"
+	conf.Connection.AccessKeyID = "test-access-key"
+	conf.Connection.SecretAccessKey = "test-secret-key"
+
+	var buf bytes.Buffer
+	assert.NoError(t, New(conf, measurements.Metrics{}, nil).writeJSON(&buf))
+	assert.NotContains(t, buf.String(), "test-access-key")
+	assert.NotContains(t, buf.String(), "test-secret-key")
+
+	var doc struct {
+		Config struct {
+			Benchmark  map[string]interface{} `json:"benchmark"`
+			Connection map[string]interface{} `json:"connection"`
+		} `json:"config"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "mysql", doc.Config.Benchmark["measuredSystem"])
+	assert.Equal(t, "db:3306", doc.Config.Connection["dbEndpoint"])
+}
@@ -0,0 +1,75 @@
+package benchmark
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/distributions"
+)
+
+// CheckSamplers samples from each configured histogram (VotesPerStory,
+// VotesPerComment, CommentsPerStory) as many times as it has values, and
+// writes to w how closely the number of samples per ID follows the
+// histogram, with chi-square and Kolmogorov-Smirnov tests.
+func CheckSamplers(configFile string, seed int64, w io.Writer) error {
+	conf, err := config.GetConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if seed == 0 {
+		seed = conf.Benchmark.Seed
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	mode := conf.Distributions.SamplerMode
+	if mode == "" {
+		mode = "fixed"
+	}
+
+	for _, h := range []struct {
+		name     string
+		hist     []config.Bin
+		averages []float64
+	}{
+		{"VotesPerStory", conf.Distributions.VotesPerStory, conf.Distributions.Averages.VotesPerStory},
+		{"VotesPerComment", conf.Distributions.VotesPerComment, conf.Distributions.Averages.VotesPerComment},
+		{"CommentsPerStory", conf.Distributions.CommentsPerStory, conf.Distributions.Averages.CommentsPerStory},
+	} {
+		if len(h.hist) == 0 {
+			continue
+		}
+
+		sampler, err := distributions.NewSamplerMode(mode, h.hist, h.averages)
+		if err != nil {
+			return err
+		}
+		samples := distributions.Total(h.hist, h.averages)
+		f := distributions.CheckSampler(sampler, h.hist, samples, r)
+
+		if _, err := fmt.Fprintf(w, "%s (sampler mode: %s, seed: %d, samples: %d)\n", h.name, mode, seed, samples); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%10s %10s %10s\n", "bin", "target", "sampled"); err != nil {
+			return err
+		}
+		for i, d := range h.hist {
+			if _, err := fmt.Fprintf(w, "%10d %10d %10d\n", d.Bin, f.Target[i], f.Sampled[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "chi-square: %.2f (dof: %d, p: %.4f)\n", f.ChiSquare, f.DegreesOfFreedom, f.ChiSquareP); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "KS: %.4f (p: %.4f)\n\n", f.KS, f.KSP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package benchmark
+
+import (
+	"context"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/datastore"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schema creates the benchmark schema in the configured database.
+// Existing tables are left as they are.
+func Schema(configFile string) error {
+	ds, err := openDatastore(configFile)
+	if err != nil {
+		return err
+	}
+	defer ds.Db.Close()
+
+	if err := ds.CreateSchema(context.Background()); err != nil {
+		return err
+	}
+	log.Info("schema created")
+
+	return nil
+}
+
+// Reset empties the tables of the benchmark schema in the configured
+// database, creating the ones that do not exist.
+func Reset(configFile string) error {
+	ds, err := openDatastore(configFile)
+	if err != nil {
+		return err
+	}
+	defer ds.Db.Close()
+
+	if err := ds.CreateSchema(context.Background()); err != nil {
+		return err
+	}
+	if err := ds.Reset(context.Background()); err != nil {
+		return err
+	}
+	log.Info("tables truncated")
+
+	return nil
+}
+
+func openDatastore(configFile string) (datastore.Datastore, error) {
+	conf, err := config.GetConfig(configFile)
+	if err != nil {
+		return datastore.Datastore{}, err
+	}
+
+	// the tables may not exist yet, so there is nothing to prepare
+	return datastore.NewDatastore(conf.Connection.DBEndpoint, conf.Connection.Database, conf.Connection.AccessKeyID, conf.Connection.SecretAccessKey, "interpolated")
+}
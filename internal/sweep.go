@@ -0,0 +1,142 @@
+package benchmark
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	log "github.com/sirupsen/logrus"
+)
+
+// SweepStep is the outcome of running the benchmark at a given target load.
+type SweepStep struct {
+	Load      int64
+	Metrics   measurements.Metrics
+	Saturated bool
+}
+
+// Sweep runs the benchmark (warmup and measurement) at increasing target
+// loads, as configured in the [Sweep] section, and stops when the system
+// saturates: when throughput falls short of the offered load, or the
+// corrected p99 latency exceeds the SLO.
+// In bisect mode, it instead bisects [From, To] down to Step.
+// Steps are returned sorted by load.
+func Sweep(configFile string, threadCnt int, maxInFlightR, maxInFlightW, seed int64) ([]SweepStep, error) {
+	conf, err := config.GetConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	sweep := conf.Sweep
+	if sweep.From <= 0 || sweep.To < sweep.From || sweep.Step <= 0 {
+		return nil, errors.New("invalid sweep range")
+	}
+
+	runStep := func(load int64) (SweepStep, error) {
+		log.WithFields(log.Fields{"load": load}).Info("sweep step")
+
+		bench, err := NewBenchmark(configFile, false, threadCnt, load, maxInFlightR, maxInFlightW, seed, false, nil)
+		if err != nil {
+			return SweepStep{}, err
+		}
+		if err := bench.Run(); err != nil {
+			return SweepStep{}, err
+		}
+		metrics, err := bench.measurements.CalculateMetrics(ioutil.Discard, ioutil.Discard)
+		if err != nil {
+			return SweepStep{}, err
+		}
+
+		// the load is split evenly across threads, rounding down, so the
+		// offered load can be lower than the nominal one
+		offered := bench.config.Benchmark.TargetLoad * int64(bench.config.Benchmark.ThreadCount)
+		step := SweepStep{
+			Load:      load,
+			Metrics:   metrics,
+			Saturated: saturated(metrics, offered, sweep.SLO, sweep.Tolerance),
+		}
+		log.WithFields(log.Fields{"load": load, "throughput": metrics.Throughput, "saturated": step.Saturated}).Info("sweep step done")
+
+		return step, nil
+	}
+
+	steps := make([]SweepStep, 0)
+
+	if sweep.Bisect {
+		low, high := sweep.From, sweep.To
+		for high-low > sweep.Step {
+			step, err := runStep(low + (high-low)/2)
+			if err != nil {
+				return steps, err
+			}
+			steps = append(steps, step)
+			if step.Saturated {
+				high = step.Load
+			} else {
+				low = step.Load
+			}
+		}
+		sort.Slice(steps, func(i, j int) bool { return steps[i].Load < steps[j].Load })
+		return steps, nil
+	}
+
+	for load := sweep.From; load <= sweep.To; load += sweep.Step {
+		step, err := runStep(load)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+		if step.Saturated {
+			break
+		}
+	}
+
+	return steps, nil
+}
+
+// saturated returns true if the throughput fell short of the offered load,
+// or the corrected p99 latency exceeded the SLO.
+func saturated(m measurements.Metrics, offered int64, slo, tolerance float64) bool {
+	if m.Throughput < float64(offered)*(1-tolerance) {
+		return true
+	}
+	return slo > 0 && m.TotalMetrics.CorrectedP99 > slo
+}
+
+// PrintSweep writes the sweep steps as a table, followed by the highest
+// load the system sustained.
+func PrintSweep(w io.Writer, steps []SweepStep) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "load\tthroughput\tp50(ms)\tp90(ms)\tp95(ms)\tp99(ms)\tcorrected p99(ms)\tsaturated"); err != nil {
+		return err
+	}
+
+	var maxSustained int64
+	for _, s := range steps {
+		if _, err := fmt.Fprintf(tw, "%d\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%t\n",
+			s.Load,
+			s.Metrics.Throughput,
+			s.Metrics.TotalMetrics.P50,
+			s.Metrics.TotalMetrics.P90,
+			s.Metrics.TotalMetrics.P95,
+			s.Metrics.TotalMetrics.P99,
+			s.Metrics.TotalMetrics.CorrectedP99,
+			s.Saturated,
+		); err != nil {
+			return err
+		}
+		if !s.Saturated && s.Load > maxSustained {
+			maxSustained = s.Load
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "Max sustained load: %d\n", maxSustained)
+	return err
+}
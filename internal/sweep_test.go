@@ -0,0 +1,36 @@
+package benchmark
+
+import (
+	"testing"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaturated(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		throughput float64
+		p99        float64
+		offered    int64
+		slo        float64
+		tolerance  float64
+		want       bool
+	}{
+		{"keeps up", 100, 10, 100, 0, .05, false},
+		{"within tolerance", 95.5, 10, 100, 0, .05, false},
+		{"falls short", 94, 10, 100, 0, .05, true},
+		{"no tolerance", 99.9, 10, 100, 0, 0, true},
+		// -t 3 at load 10 offers 9 ops/s
+		{"offered below nominal", 9, 10, 9, 0, .05, false},
+		{"within slo", 100, 49, 100, 50, .05, false},
+		{"above slo", 100, 51, 100, 50, .05, true},
+		{"slo disabled", 100, 1000, 100, 0, .05, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := measurements.Metrics{Throughput: tc.throughput}
+			m.TotalMetrics.CorrectedP99 = tc.p99
+			assert.Equal(t, tc.want, saturated(m, tc.offered, tc.slo, tc.tolerance))
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/distributions"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+)
+
+// Preload populates the datastore with the configured number of users,
+// stories, comments and votes.
+// Rows left by a previous preload are kept, and only the missing ones are
+// inserted, so that an interrupted preload can be resumed by running it
+// again. Preloads that failed to insert a user or story leave a gap in its
+// IDs, and cannot be resumed: the datastore needs to be reset.
+func (w Workload) Preload() error {
+	fmt.Println("Preloading ..")
+	ctx := context.Background()
+	st := time.Now()
+
+	state, err := w.ops.PreloadState(ctx)
+	if err != nil {
+		return err
+	}
+	if err := resumable(state); err != nil {
+		return err
+	}
+	if state != (operations.PreloadState{}) {
+		fmt.Printf("Resuming: found %d users, %d stories, %d comments, %d votes\n", state.Users, state.Stories, state.Comments, state.StoryVotes)
+	}
+
+	switch w.config.Preload.Mode {
+	case "", "rows":
+		err = w.preloadRows(ctx, state)
+	case "bulk":
+		err = w.preloadBulk(ctx, state)
+	default:
+		err = errors.New("unknown preload mode")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Preloading done (%s)\n", time.Since(st).Round(time.Second))
+	return w.printHistograms(ctx)
+}
+
+// resumable returns an error if the users or stories of a previous preload
+// have gaps in their IDs, as new ones get the IDs after the largest existing
+// one.
+func resumable(state operations.PreloadState) error {
+	if state.MaxUserID != state.Users || state.MaxStoryID != state.Stories {
+		return fmt.Errorf("cannot resume: %d users up to ID %d, %d stories up to ID %d; reset the datastore and preload again", state.Users, state.MaxUserID, state.Stories, state.MaxStoryID)
+	}
+	return nil
+}
+
+// preloadRows inserts one row per statement, through the same operations
+// as the benchmark.
+func (w Workload) preloadRows(ctx context.Context, state operations.PreloadState) error {
+	rc := w.config.Preload.RecordCount
+
+	w.ops.StoryID = state.MaxStoryID
+	w.ops.UserID = state.MaxUserID
+
+	p := newProgress("users", remaining(rc.Users, state.Users))
+	err := w.parallel(ctx, remaining(rc.Users, state.Users), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		for i := int64(0); i < count; i++ {
+			if err := w.ops.AddUser(ctx); err != nil {
+				return err
+			}
+			p.add(1)
+		}
+		return nil
+	})
+	if err := p.finish(err); err != nil {
+		return err
+	}
+
+	p = newProgress("stories", remaining(rc.Stories, state.Stories))
+	err = w.parallel(ctx, remaining(rc.Stories, state.Stories), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		for i := int64(0); i < count; i++ {
+			if _, err := w.ops.Submit(ctx, w.ops.ActiveUser(r), operations.RandString(r, 30), 0); err != nil {
+				return err
+			}
+			p.add(1)
+		}
+		return nil
+	})
+	if err := p.finish(err); err != nil {
+		return err
+	}
+
+	if perStory := w.ops.CommentsPerStory(); perStory != nil {
+		existing := make([]int64, len(perStory))
+		if err := w.ops.StoryCommentCounts(ctx, existing); err != nil {
+			return err
+		}
+		err = w.followHistogram(ctx, "comments", perStory, existing, 1, w.activeUsers, func(ctx context.Context, r *rand.Rand, userIDs, storyIDs []int64) error {
+			_, err := w.ops.Comment(ctx, userIDs[0], storyIDs[0], operations.RandString(r, 20))
+			return err
+		})
+	} else {
+		p = newProgress("comments", remaining(rc.Comments, state.Comments))
+		err = p.finish(w.parallel(ctx, remaining(rc.Comments, state.Comments), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+			for i := int64(0); i < count; i++ {
+				if _, err := w.ops.Comment(ctx, w.ops.ActiveUser(r), w.ops.CommentStoryID(r, 0), operations.RandString(r, 20)); err != nil {
+					return err
+				}
+				p.add(1)
+			}
+			return nil
+		}))
+	}
+	if err != nil {
+		return err
+	}
+
+	if perStory := w.ops.VotesPerStory(); perStory != nil {
+		existing := make([]int64, len(perStory))
+		if err := w.ops.StoryVoteSums(ctx, existing); err != nil {
+			return err
+		}
+		return w.followHistogram(ctx, "votes", perStory, existing, 1, w.ops.Voters, func(ctx context.Context, r *rand.Rand, userIDs, storyIDs []int64) error {
+			_, err := w.ops.StoryVote(ctx, userIDs[0], storyIDs[0], 1, 0)
+			return err
+		})
+	}
+
+	voters, err := w.newStoryVoters(ctx, state)
+	if err != nil {
+		return err
+	}
+	p = newProgress("votes", remaining(rc.Votes, state.StoryVotes))
+	return p.finish(w.parallel(ctx, remaining(rc.Votes, state.StoryVotes), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		for i := int64(0); i < count; i++ {
+			userID, storyID := w.drawVote(r, voters, func(r *rand.Rand) int64 { return w.ops.VoteStoryID(r, 0) })
+			if _, err := w.ops.StoryVote(ctx, userID, storyID, 1, 0); err != nil {
+				return err
+			}
+			p.add(1)
+		}
+		return nil
+	}))
+}
+
+// preloadBulk inserts Preload.BatchSize rows per statement.
+// Votes are generated before stories, so that each story is inserted with
+// the vote count of the votes inserted for it.
+// Users and stories are inserted with consecutive IDs after the largest
+// existing one.
+func (w Workload) preloadBulk(ctx context.Context, state operations.PreloadState) error {
+	rc := w.config.Preload.RecordCount
+	batchSize := int64(w.config.Preload.BatchSize)
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	p := newProgress("users", remaining(rc.Users, state.Users))
+	err := w.parallel(ctx, remaining(rc.Users, state.Users), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		return batches(state.MaxUserID+first, count, batchSize, func(first, count int64) error {
+			if err := w.ops.InsertUsers(ctx, first+1, count); err != nil {
+				return err
+			}
+			p.add(count)
+			return nil
+		})
+	})
+	if err := p.finish(err); err != nil {
+		return err
+	}
+
+	// indexed by story ID, including the votes of a previous preload
+	voteSums := make([]int64, state.MaxStoryID+remaining(rc.Stories, state.Stories)+1)
+	if state.StoryVotes > 0 {
+		if err := w.ops.StoryVoteSums(ctx, voteSums); err != nil {
+			return err
+		}
+	}
+
+	if perStory := w.ops.VotesPerStory(); perStory != nil {
+		existing := make([]int64, len(perStory))
+		copy(existing, voteSums)
+		err = w.followHistogram(ctx, "votes", perStory, existing, batchSize, w.ops.Voters, func(ctx context.Context, r *rand.Rand, userIDs, storyIDs []int64) error {
+			return w.ops.InsertStoryVotes(ctx, userIDs, storyIDs, 1)
+		})
+		for id := 1; id < len(perStory) && id < len(voteSums); id++ {
+			if perStory[id] > voteSums[id] {
+				voteSums[id] = perStory[id]
+			}
+		}
+	} else {
+		var voters *storyVoters
+		if voters, err = w.newStoryVoters(ctx, state); err != nil {
+			return err
+		}
+		sampleStory := func(r *rand.Rand) int64 { return w.preloadedStoryID(r, w.ops.VoteStoryID) }
+		p = newProgress("votes", remaining(rc.Votes, state.StoryVotes))
+		err = p.finish(w.parallel(ctx, remaining(rc.Votes, state.StoryVotes), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+			return batches(first, count, batchSize, func(first, count int64) error {
+				userIDs := make([]int64, count)
+				storyIDs := make([]int64, count)
+				for i := range storyIDs {
+					userIDs[i], storyIDs[i] = w.drawVote(r, voters, sampleStory)
+					if storyIDs[i] < int64(len(voteSums)) {
+						atomic.AddInt64(&voteSums[storyIDs[i]], 1)
+					}
+				}
+				if err := w.ops.InsertStoryVotes(ctx, userIDs, storyIDs, 1); err != nil {
+					return err
+				}
+				p.add(count)
+				return nil
+			})
+		}))
+	}
+	if err != nil {
+		return err
+	}
+
+	p = newProgress("stories", remaining(rc.Stories, state.Stories))
+	err = w.parallel(ctx, remaining(rc.Stories, state.Stories), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		return batches(state.MaxStoryID+first, count, batchSize, func(first, count int64) error {
+			userIDs := make([]int64, count)
+			descriptions := make([]string, count)
+			for i := range descriptions {
+				userIDs[i] = w.ops.ActiveUser(r)
+				descriptions[i] = operations.RandString(r, 30)
+			}
+			if err := w.ops.InsertStories(ctx, first+1, userIDs, descriptions, voteSums[first+1:first+1+count]); err != nil {
+				return err
+			}
+			p.add(count)
+			return nil
+		})
+	})
+	if err := p.finish(err); err != nil {
+		return err
+	}
+
+	if perStory := w.ops.CommentsPerStory(); perStory != nil {
+		existing := make([]int64, len(perStory))
+		if err := w.ops.StoryCommentCounts(ctx, existing); err != nil {
+			return err
+		}
+		return w.followHistogram(ctx, "comments", perStory, existing, batchSize, w.activeUsers, func(ctx context.Context, r *rand.Rand, userIDs, storyIDs []int64) error {
+			comments := make([]string, len(storyIDs))
+			for i := range comments {
+				comments[i] = operations.RandString(r, 20)
+			}
+			return w.ops.InsertComments(ctx, userIDs, storyIDs, comments)
+		})
+	}
+
+	p = newProgress("comments", remaining(rc.Comments, state.Comments))
+	return p.finish(w.parallel(ctx, remaining(rc.Comments, state.Comments), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		return batches(first, count, batchSize, func(first, count int64) error {
+			userIDs := make([]int64, count)
+			storyIDs := make([]int64, count)
+			comments := make([]string, count)
+			for i := range storyIDs {
+				userIDs[i] = w.ops.ActiveUser(r)
+				storyIDs[i] = w.preloadedStoryID(r, w.ops.CommentStoryID)
+				comments[i] = operations.RandString(r, 20)
+			}
+			if err := w.ops.InsertComments(ctx, userIDs, storyIDs, comments); err != nil {
+				return err
+			}
+			p.add(count)
+			return nil
+		})
+	}))
+}
+
+// followHistogram inserts, for each story, the rows (votes, comments) it is
+// missing to reach its count in perStory, given its existing ones.
+// Stories are split among the preload threads, and insert is called with
+// (at most) batchSize rows at a time, given by their user and story IDs.
+// users picks the users of the n rows of a story.
+func (w Workload) followHistogram(ctx context.Context, table string, perStory, existing []int64, batchSize int64, users func(r *rand.Rand, n int64) []int64, insert func(ctx context.Context, r *rand.Rand, userIDs, storyIDs []int64) error) error {
+	var total int64
+	for id := 1; id < len(perStory); id++ {
+		total += remaining(perStory[id], existing[id])
+	}
+
+	p := newProgress(table, total)
+	return p.finish(w.parallel(ctx, int64(len(perStory)-1), func(ctx context.Context, r *rand.Rand, first, count int64) error {
+		userIDs := make([]int64, 0, batchSize)
+		batch := make([]int64, 0, batchSize)
+		flush := func() error {
+			if err := insert(ctx, r, userIDs, batch); err != nil {
+				return err
+			}
+			p.add(int64(len(batch)))
+			userIDs = userIDs[:0]
+			batch = batch[:0]
+			return nil
+		}
+
+		for id := first + 1; id <= first+count; id++ {
+			for _, userID := range users(r, remaining(perStory[id], existing[id])) {
+				userIDs = append(userIDs, userID)
+				batch = append(batch, id)
+				if int64(len(batch)) == batchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if len(batch) > 0 {
+			return flush()
+		}
+		return nil
+	}))
+}
+
+// preloadedStoryID draws a story with sample, among the
+// Preload.RecordCount.Stories preloaded ones: samplers that follow a
+// histogram draw IDs over all of its bins, which can cover more stories.
+func (w Workload) preloadedStoryID(r *rand.Rand, sample func(r *rand.Rand, at time.Duration) int64) int64 {
+	for {
+		if storyID := sample(r, 0); storyID <= w.config.Preload.RecordCount.Stories {
+			return storyID
+		}
+	}
+}
+
+// storyVoters holds the user and story of each story vote, so that
+// preloads under DuplicateVotes "prevent" do not draw votes that would be
+// rejected as duplicates.
+type storyVoters struct {
+	mu    sync.Mutex
+	voted map[[2]int64]bool
+}
+
+// newStoryVoters returns the story votes of the datastore, or nil if
+// duplicate votes are allowed.
+func (w Workload) newStoryVoters(ctx context.Context, state operations.PreloadState) (*storyVoters, error) {
+	if w.config.Operations.DuplicateVotes != "prevent" {
+		return nil, nil
+	}
+	rc := w.config.Preload.RecordCount
+	if rc.Votes > rc.Users*rc.Stories {
+		return nil, errors.New("more votes than pairs of users and stories, with duplicate votes prevented")
+	}
+
+	v := &storyVoters{voted: make(map[[2]int64]bool, rc.Votes)}
+	if state.StoryVotes == 0 {
+		return v, nil
+	}
+	return v, w.ops.StoryVoters(ctx, func(userID, storyID int64) {
+		v.voted[[2]int64{userID, storyID}] = true
+	})
+}
+
+// add records a vote, and returns false if the user already voted for the
+// story.
+func (v *storyVoters) add(userID, storyID int64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := [2]int64{userID, storyID}
+	if v.voted[key] {
+		return false
+	}
+	v.voted[key] = true
+	return true
+}
+
+// drawVote picks the user and the story of a vote, drawn with sampleStory,
+// that is not in voters, if set.
+// Users outside the user distribution are picked uniformly once the
+// distribution has trouble finding a new pair.
+func (w Workload) drawVote(r *rand.Rand, voters *storyVoters, sampleStory func(r *rand.Rand) int64) (userID, storyID int64) {
+	for attempts := 0; ; attempts++ {
+		userID = w.ops.ActiveUser(r)
+		if attempts > 10 {
+			userID = w.ops.RandomUser(r)
+		}
+		storyID = sampleStory(r)
+		if voters == nil || voters.add(userID, storyID) {
+			return userID, storyID
+		}
+	}
+}
+
+// activeUsers picks n users, independently.
+func (w Workload) activeUsers(r *rand.Rand, n int64) []int64 {
+	users := make([]int64, n)
+	for i := range users {
+		users[i] = w.ops.ActiveUser(r)
+	}
+	return users
+}
+
+// printHistograms compares the votes and comments per story in the
+// datastore with the configured histograms.
+func (w Workload) printHistograms(ctx context.Context) error {
+	stories := w.config.Preload.RecordCount.Stories
+	for _, h := range []struct {
+		name     string
+		hist     []config.Bin
+		perStory func(context.Context, []int64) error
+	}{
+		{"VotesPerStory", w.config.Distributions.VotesPerStory, w.ops.StoryVoteSums},
+		{"CommentsPerStory", w.config.Distributions.CommentsPerStory, w.ops.StoryCommentCounts},
+	} {
+		if len(h.hist) == 0 {
+			continue
+		}
+
+		values := make([]int64, stories+1)
+		if err := h.perStory(ctx, values); err != nil {
+			return err
+		}
+		target := distributions.ScaleCounts(h.hist, stories)
+		generated := distributions.Bins(h.hist, values)
+
+		fmt.Printf("%s\n%10s %10s %10s\n", h.name, "bin", "target", "generated")
+		for i, d := range h.hist {
+			fmt.Printf("%10d %10d %10d\n", d.Bin, target[i], generated[i])
+		}
+	}
+	return nil
+}
+
+// Verify checks that the datastore holds the dataset described by the
+// preload configuration.
+func (w Workload) Verify() error {
+	fmt.Println("Verifying dataset ..")
+	return w.ops.VerifyPreload(context.Background())
+}
+
+// remaining returns how many of the target rows are missing.
+func remaining(target, existing int64) int64 {
+	if existing >= target {
+		return 0
+	}
+	return target - existing
+}
+
+// parallel splits count rows among the preload threads, and calls f on each
+// thread with the thread's random source and its share of the rows (starting
+// from first, 0-based).
+// It returns the first error returned by f, and cancels the other threads.
+func (w Workload) parallel(ctx context.Context, count int64, f func(ctx context.Context, r *rand.Rand, first, count int64) error) error {
+	threads := int64(w.config.Preload.Threads)
+	if threads <= 0 {
+		threads = 10
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, threads)
+
+	var first int64
+	for t := int64(1); t <= threads; t++ {
+		share := count / threads
+		if t <= count%threads {
+			share++
+		}
+
+		wg.Add(1)
+		go func(r *rand.Rand, first, count int64) {
+			defer wg.Done()
+			if err := f(ctx, r, first, count); err != nil {
+				errCh <- err
+				cancel()
+			}
+		}(w.rand(int(t)), first, share)
+
+		first += share
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	// nil if no thread failed
+	return <-errCh
+}
+
+// batches calls f for consecutive batches of (at most) size rows, out of the
+// count rows starting from first.
+func batches(first, count, size int64, f func(first, count int64) error) error {
+	for i := int64(0); i < count; i += size {
+		n := size
+		if count-i < n {
+			n = count - i
+		}
+		if err := f(first+i, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// progress periodically reports how many rows of a table have been inserted,
+// and at which rate.
+type progress struct {
+	table    string
+	total    int64
+	inserted int64
+	start    time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+const progressInterval = 5 * time.Second
+
+func newProgress(table string, total int64) *progress {
+	p := &progress{
+		table: table,
+		total: total,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				inserted := atomic.LoadInt64(&p.inserted)
+				fmt.Printf("[preload] %s: %d/%d (%.0f rows/s)\n", p.table, inserted, p.total, p.rate(inserted))
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) add(n int64) {
+	atomic.AddInt64(&p.inserted, n)
+}
+
+func (p *progress) rate(inserted int64) float64 {
+	return float64(inserted) / time.Since(p.start).Seconds()
+}
+
+// finish stops the periodic reports, and prints the outcome of the table's
+// preload.
+// It returns err, for convenience.
+func (p *progress) finish(err error) error {
+	close(p.done)
+	p.wg.Wait()
+
+	inserted := atomic.LoadInt64(&p.inserted)
+	if err != nil {
+		fmt.Printf("Failed after %d/%d %s\n", inserted, p.total, p.table)
+		return err
+	}
+	fmt.Printf("Created %d %s (%.0f rows/s)\n", inserted, p.table, p.rate(inserted))
+	return nil
+}
@@ -0,0 +1,131 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/datastore"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDBConfig returns a preload configuration for the MySQL database given
+// by the LOBSTERS_TEST_DB_* environment variables, after emptying it.
+// The test is skipped if LOBSTERS_TEST_DB_ENDPOINT is not set.
+func testDBConfig(t *testing.T) *config.BenchmarkConfig {
+	endpoint := os.Getenv("LOBSTERS_TEST_DB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("LOBSTERS_TEST_DB_ENDPOINT is not set")
+	}
+
+	conf := &config.BenchmarkConfig{}
+	conf.Connection.DBEndpoint = endpoint
+	conf.Connection.Database = os.Getenv("LOBSTERS_TEST_DB_DATABASE")
+	conf.Connection.AccessKeyID = os.Getenv("LOBSTERS_TEST_DB_USER")
+	conf.Connection.SecretAccessKey = os.Getenv("LOBSTERS_TEST_DB_PASSWORD")
+	conf.Benchmark.MeasuredSystem = "baseline"
+	conf.Benchmark.WorkloadType = "simple"
+	conf.Benchmark.DoPreload = true
+	conf.Benchmark.Seed = 1
+	conf.Operations.Homepage.StoriesLimit = 10
+	conf.Operations.Comments.CommentsLimit = 10
+	conf.Operations.DistributionType = "uniform"
+	conf.Operations.StoryReadDistribution = "uniform"
+	conf.Operations.CommentStoryDistribution = "uniform"
+
+	ds, err := datastore.NewDatastore(conf.Connection.DBEndpoint, conf.Connection.Database, conf.Connection.AccessKeyID, conf.Connection.SecretAccessKey, "interpolated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Db.Close()
+	if err := ds.CreateSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Reset(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return conf
+}
+
+func TestPreloadRowsVerify(t *testing.T) {
+	conf := testDBConfig(t)
+	conf.Preload.Mode = "rows"
+	conf.Preload.Threads = 8
+	conf.Preload.RecordCount.Users = 50
+	conf.Preload.RecordCount.Stories = 400
+	conf.Preload.RecordCount.Comments = 200
+	conf.Preload.RecordCount.Votes = 1000
+
+	w, err := NewWorkload(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	assert.NoError(t, w.Preload())
+	assert.NoError(t, w.Verify())
+}
+
+func TestPreloadRowsPreventDuplicateVotes(t *testing.T) {
+	conf := testDBConfig(t)
+	conf.Operations.DuplicateVotes = "prevent"
+	conf.Preload.Mode = "rows"
+	conf.Preload.Threads = 8
+	// most of the pairs of users and stories are voted
+	conf.Preload.RecordCount.Users = 10
+	conf.Preload.RecordCount.Stories = 20
+	conf.Preload.RecordCount.Votes = 150
+
+	w, err := NewWorkload(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// no vote is rejected as a duplicate, so that the vote count matches
+	assert.NoError(t, w.Preload())
+	assert.NoError(t, w.Verify())
+}
+
+func TestPreloadedStoryID(t *testing.T) {
+	conf := &config.BenchmarkConfig{}
+	conf.Preload.RecordCount.Stories = 10
+	w := Workload{config: conf}
+
+	// a sampler whose histogram covers twice as many stories
+	sampler := rand.New(rand.NewSource(1))
+	sample := func(*rand.Rand, time.Duration) int64 { return sampler.Int63n(20) + 1 }
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		storyID := w.preloadedStoryID(r, sample)
+		assert.True(t, storyID >= 1 && storyID <= 10, storyID)
+	}
+}
+
+func TestResumable(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		state   operations.PreloadState
+		wantErr bool
+	}{
+		{"empty", operations.PreloadState{}, false},
+		{"contiguous", operations.PreloadState{Users: 5, MaxUserID: 5, Stories: 10, MaxStoryID: 10, Comments: 3}, false},
+		{"user gap", operations.PreloadState{Users: 4, MaxUserID: 5, Stories: 10, MaxStoryID: 10}, true},
+		{"story gap", operations.PreloadState{Users: 5, MaxUserID: 5, Stories: 9, MaxStoryID: 10}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := resumable(tc.state)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
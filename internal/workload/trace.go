@@ -0,0 +1,235 @@
+package workload
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+)
+
+// A trace is a CSV file with one operation per line.
+// offset_us is the time the operation was scheduled at, relative to the start
+// of its client, and target is the story, comment or user ID the operation
+// acts on (0 if none), and user the user that issues it (0 if none).
+// Traces recorded before the user column was added are read with no users.
+var traceHeader = []string{"client", "offset_us", "op", "target", "vote", "text", "user"}
+
+// TraceRecord is an operation of a recorded workload.
+type TraceRecord struct {
+	ClientID int
+	Offset   time.Duration
+	OpKind   measurements.OpKind
+	Target   int64
+	UserID   int64
+	Vote     int
+	Text     string
+}
+
+// Recorder writes the operations generated by the workload to a trace file.
+// It is shared by all clients.
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+// NewRecorder creates the trace file.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := csv.NewWriter(f)
+	if err := w.Write(traceHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Recorder{
+		f: f,
+		w: w,
+	}, nil
+}
+
+// Record appends an operation to the trace.
+func (rec *Recorder) Record(clientID int, offset time.Duration, op operations.Operation) error {
+	r := traceRecord(op)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.w.Write([]string{
+		strconv.Itoa(clientID),
+		strconv.FormatInt(offset.Microseconds(), 10),
+		r.OpKind.String(),
+		strconv.FormatInt(r.Target, 10),
+		strconv.Itoa(r.Vote),
+		r.Text,
+		strconv.FormatInt(r.UserID, 10),
+	})
+}
+
+// Close flushes and closes the trace file.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.w.Flush()
+	if err := rec.w.Error(); err != nil {
+		rec.f.Close()
+		return err
+	}
+	return rec.f.Close()
+}
+
+func traceRecord(op operations.Operation) TraceRecord {
+	switch o := op.(type) {
+	case operations.Frontpage:
+		return TraceRecord{OpKind: measurements.Frontpage}
+	case operations.Story:
+		return TraceRecord{OpKind: measurements.Story, Target: o.StoryID}
+	case operations.User:
+		return TraceRecord{OpKind: measurements.User, Target: o.UserID}
+	case operations.Comments:
+		return TraceRecord{OpKind: measurements.Comments}
+	case operations.Recent:
+		return TraceRecord{OpKind: measurements.Recent}
+	case operations.Login:
+		return TraceRecord{OpKind: measurements.Login, Target: o.UserID}
+	case operations.Logout:
+		return TraceRecord{OpKind: measurements.Logout}
+	case operations.StoryVote:
+		return TraceRecord{OpKind: measurements.StoryVote, Target: o.StoryID, UserID: o.UserID, Vote: o.Vote}
+	case operations.CommentVote:
+		return TraceRecord{OpKind: measurements.CommentVote, Target: o.CommentID, UserID: o.UserID, Vote: o.Vote}
+	case operations.Comment:
+		return TraceRecord{OpKind: measurements.Comment, Target: o.StoryID, UserID: o.UserID, Text: o.Text}
+	case operations.EditComment:
+		return TraceRecord{OpKind: measurements.EditComment, Target: o.CommentID, Text: o.Text}
+	case operations.Submit:
+		return TraceRecord{OpKind: measurements.Submit, UserID: o.UserID, Text: o.Description}
+	default:
+		panic(fmt.Sprintf("unexpected operation type %T", op))
+	}
+}
+
+// traceOp rebuilds the operation of a trace record.
+func traceOp(ops *operations.Operations, r TraceRecord) operations.Operation {
+	switch r.OpKind {
+	case measurements.Frontpage:
+		return operations.Frontpage{Ops: ops}
+	case measurements.Story:
+		return operations.Story{Ops: ops, StoryID: r.Target}
+	case measurements.User:
+		return operations.User{Ops: ops, UserID: r.Target}
+	case measurements.Comments:
+		return operations.Comments{Ops: ops}
+	case measurements.Recent:
+		return operations.Recent{Ops: ops}
+	case measurements.Login:
+		return operations.Login{Ops: ops, UserID: r.Target}
+	case measurements.Logout:
+		return operations.Logout{Ops: ops}
+	case measurements.StoryVote:
+		return operations.StoryVote{Ops: ops, Vote: r.Vote, StoryID: r.Target, UserID: r.UserID}
+	case measurements.CommentVote:
+		return operations.CommentVote{Ops: ops, Vote: r.Vote, CommentID: r.Target, UserID: r.UserID}
+	case measurements.Comment:
+		return operations.Comment{Ops: ops, StoryID: r.Target, UserID: r.UserID, Text: r.Text}
+	case measurements.EditComment:
+		return operations.EditComment{Ops: ops, CommentID: r.Target, Text: r.Text}
+	default:
+		return operations.Submit{Ops: ops, UserID: r.UserID, Description: r.Text, At: r.Offset}
+	}
+}
+
+// ReadTrace reads a trace file, and returns the recorded operations of each
+// client, in the order they were scheduled.
+func ReadTrace(path string) (map[int][]TraceRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+
+	// all records have as many fields as the header
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < len(traceHeader) {
+		return nil, errors.New("unexpected trace header")
+	}
+
+	trace := make(map[int][]TraceRecord)
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := parseTraceRecord(fields)
+		if err != nil {
+			return nil, err
+		}
+		trace[rec.ClientID] = append(trace[rec.ClientID], rec)
+	}
+
+	if len(trace) == 0 {
+		return nil, errors.New("empty trace")
+	}
+
+	// the users of a closed-loop client record their operations concurrently
+	for _, records := range trace {
+		sort.SliceStable(records, func(i, j int) bool { return records[i].Offset < records[j].Offset })
+	}
+
+	return trace, nil
+}
+
+func parseTraceRecord(fields []string) (TraceRecord, error) {
+	var rec TraceRecord
+	var err error
+
+	if len(fields) < len(traceHeader) {
+		return rec, errors.New("missing trace fields")
+	}
+	if rec.ClientID, err = strconv.Atoi(fields[0]); err != nil {
+		return rec, err
+	}
+	offset, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return rec, err
+	}
+	rec.Offset = time.Duration(offset) * time.Microsecond
+	if rec.OpKind, err = measurements.ParseOpKind(fields[2]); err != nil {
+		return rec, err
+	}
+	if rec.Target, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return rec, err
+	}
+	if rec.Vote, err = strconv.Atoi(fields[4]); err != nil {
+		return rec, err
+	}
+	rec.Text = fields[5]
+	if rec.UserID, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return rec, err
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,158 @@
+package workload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvasilas/proteus-lobsters-bench/internal/measurements"
+	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTracePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "trace.csv"), func() { os.RemoveAll(dir) }
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	path, cleanup := testTracePath(t)
+	defer cleanup()
+
+	records := []struct {
+		clientID int
+		offset   time.Duration
+		op       operations.Operation
+		want     TraceRecord
+	}{
+		{0, 0, operations.Frontpage{}, TraceRecord{OpKind: measurements.Frontpage}},
+		{0, 10 * time.Microsecond, operations.Story{StoryID: 7}, TraceRecord{OpKind: measurements.Story, Target: 7}},
+		{0, 20 * time.Microsecond, operations.User{UserID: 3}, TraceRecord{OpKind: measurements.User, Target: 3}},
+		{0, 30 * time.Microsecond, operations.Comments{}, TraceRecord{OpKind: measurements.Comments}},
+		{0, 40 * time.Microsecond, operations.Recent{}, TraceRecord{OpKind: measurements.Recent}},
+		{0, 50 * time.Microsecond, operations.Login{UserID: 4}, TraceRecord{OpKind: measurements.Login, Target: 4}},
+		{0, 60 * time.Microsecond, operations.Logout{}, TraceRecord{OpKind: measurements.Logout}},
+		{1, 0, operations.StoryVote{Vote: -1, StoryID: 8, UserID: 5}, TraceRecord{OpKind: measurements.StoryVote, Target: 8, UserID: 5, Vote: -1}},
+		{1, 10 * time.Microsecond, operations.CommentVote{Vote: 1, CommentID: 9, UserID: 6}, TraceRecord{OpKind: measurements.CommentVote, Target: 9, UserID: 6, Vote: 1}},
+		{1, 20 * time.Microsecond, operations.Comment{StoryID: 10, UserID: 7, Text: "a \"quoted\", multi\nline comment"}, TraceRecord{OpKind: measurements.Comment, Target: 10, UserID: 7, Text: "a \"quoted\", multi\nline comment"}},
+		{1, 30 * time.Microsecond, operations.EditComment{CommentID: 11, Text: "edited"}, TraceRecord{OpKind: measurements.EditComment, Target: 11, Text: "edited"}},
+		{1, 40 * time.Microsecond, operations.Submit{UserID: 8, Description: "story", At: 40 * time.Microsecond}, TraceRecord{OpKind: measurements.Submit, UserID: 8, Text: "story"}},
+	}
+
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// records of a client out of order, as recorded by concurrent users
+	for i := len(records) - 1; i >= 0; i-- {
+		assert.NoError(t, rec.Record(records[i].clientID, records[i].offset, records[i].op))
+	}
+	assert.NoError(t, rec.Close())
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(string(data), strings.Join(traceHeader, ",")+"\n"))
+
+	trace, err := ReadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, trace, 2)
+
+	next := make(map[int]int)
+	for _, r := range records {
+		i := next[r.clientID]
+		next[r.clientID]++
+		if !assert.Less(t, i, len(trace[r.clientID])) {
+			continue
+		}
+		got := trace[r.clientID][i]
+
+		want := r.want
+		want.ClientID = r.clientID
+		want.Offset = r.offset
+		assert.Equal(t, want, got)
+		assert.Equal(t, r.op, traceOp(nil, got))
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		trace   string
+		want    map[int][]TraceRecord
+		wantErr bool
+	}{
+		{
+			name:    "without the user column",
+			trace:   "client,offset_us,op,target,vote,text\n0,5,storyVote,2,1,\n0,1,comment,3,0,text\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown operation",
+			trace:   "client,offset_us,op,target,vote,text,user\n0,0,unknown,0,0,,0\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad offset",
+			trace:   "client,offset_us,op,target,vote,text,user\n0,x,frontpage,0,0,,0\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing fields",
+			trace:   "client,offset_us,op,target,vote,text,user\n0,0,frontpage\n",
+			wantErr: true,
+		},
+		{
+			name:    "short header",
+			trace:   "client,offset_us,op\n0,0,frontpage\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			trace:   "client,offset_us,op,target,vote,text,user\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, cleanup := testTracePath(t)
+			defer cleanup()
+			if err := ioutil.WriteFile(path, []byte(tc.trace), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			trace, err := ReadTrace(path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, trace)
+		})
+	}
+}
+
+func TestTraceClients(t *testing.T) {
+	path, cleanup := testTracePath(t)
+	defer cleanup()
+	// clients 1 and 2 issued no operations
+	trace := "client,offset_us,op,target,vote,text,user\n3,0,frontpage,0,0,,0\n0,0,frontpage,0,0,,0\n"
+	if err := ioutil.WriteFile(path, []byte(trace), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Workload{trace: records}
+	assert.Equal(t, []int{0, 3}, w.TraceClients())
+}
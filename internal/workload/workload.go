@@ -1,15 +1,17 @@
 package workload
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
-	"sync"
+	"sort"
 
 	"time"
 
 	"github.com/dvasilas/proteus-lobsters-bench/internal/config"
 	"github.com/dvasilas/proteus-lobsters-bench/internal/operations"
+	log "github.com/sirupsen/logrus"
 )
 
 // Workload ...
@@ -17,41 +19,91 @@ type Workload struct {
 	config   *config.BenchmarkConfig
 	ops      *operations.Operations
 	workload workload
+	recorder *Recorder
+	// operations of each client, for the "replay" workload type
+	trace map[int][]TraceRecord
 }
 
 type workload interface {
-	nextOp() operations.Operation
+	// at is the offset the operation is scheduled at
+	nextOp(r *rand.Rand, at time.Duration) operations.Operation
 }
 
 // NewWorkload ...
 func NewWorkload(conf *config.BenchmarkConfig) (*Workload, error) {
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	ops, err := operations.NewOperations(conf)
 	if err != nil {
 		return nil, err
 	}
 
 	var w workload
+	var trace map[int][]TraceRecord
 	switch conf.Benchmark.WorkloadType {
 	case "simple":
 		w = newWorkloadSimple(conf, ops)
 	case "complete":
 		w = newWorkloadComplete(ops)
+	case "replay":
+		// operations come from the trace, see Trace
+		trace, err = ReadTrace(conf.Benchmark.Trace.Replay)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, errors.New("unknown workload type")
 	}
 
+	var recorder *Recorder
+	if conf.Benchmark.Trace.Record != "" && conf.Benchmark.WorkloadType != "replay" {
+		recorder, err = NewRecorder(conf.Benchmark.Trace.Record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Workload{
 		ops:      ops,
 		workload: w,
 		config:   conf,
+		recorder: recorder,
+		trace:    trace,
 	}, nil
 }
 
-// NextOp ...
-func (w *Workload) NextOp() operations.Operation {
-	return w.workload.nextOp()
+// NextOp generates the next operation, including its target (story, comment,
+// user ..), using the client's random source r.
+// If recording is enabled, the operation is recorded along with the client
+// that issues it, and its scheduled time relative to the client's start.
+func (w *Workload) NextOp(r *rand.Rand, clientID int, offset time.Duration) operations.Operation {
+	op := w.workload.nextOp(r, offset)
+	if w.recorder != nil {
+		if err := w.recorder.Record(clientID, offset, op); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("trace recording failed")
+		}
+	}
+	return op
+}
+
+// TraceClients returns the IDs of the clients of the replayed trace, in
+// increasing order.
+func (w *Workload) TraceClients() []int {
+	clients := make([]int, 0, len(w.trace))
+	for clientID := range w.trace {
+		clients = append(clients, clientID)
+	}
+	sort.Ints(clients)
+	return clients
+}
+
+// Trace returns the recorded operations of the given client, in the order
+// they were scheduled.
+func (w *Workload) Trace(clientID int) []TraceRecord {
+	return w.trace[clientID]
+}
+
+// TraceOp returns the operation of a trace record.
+func (w *Workload) TraceOp(r TraceRecord) operations.Operation {
+	return traceOp(w.ops, r)
 }
 
 type workloadSimple struct {
@@ -68,15 +120,13 @@ func newWorkloadSimple(conf *config.BenchmarkConfig, ops *operations.Operations)
 	}
 }
 
-func (w workloadSimple) nextOp() operations.Operation {
-	r := rand.Float64()
-
-	if r < w.writeRatio {
-		vote := rand.Float64()
+func (w workloadSimple) nextOp(r *rand.Rand, at time.Duration) operations.Operation {
+	if r.Float64() < w.writeRatio {
+		vote := r.Float64()
 		if vote < w.downVoteRatio {
-			return operations.StoryVote{Ops: w.ops, Vote: -1}
+			return operations.StoryVote{Ops: w.ops, Vote: -1, StoryID: w.ops.VoteStoryID(r, at), UserID: w.ops.ActiveUser(r)}
 		}
-		return operations.StoryVote{Ops: w.ops, Vote: 1}
+		return operations.StoryVote{Ops: w.ops, Vote: 1, StoryID: w.ops.VoteStoryID(r, at), UserID: w.ops.ActiveUser(r)}
 	}
 
 	return operations.Frontpage{Ops: w.ops}
@@ -92,180 +142,135 @@ func newWorkloadComplete(ops *operations.Operations) workloadComplete {
 	}
 }
 
-func (w workloadComplete) nextOp() operations.Operation {
-	for true {
-		seed := rand.Intn(100000)
-		// 	55.842%  GET   /stories/X
-		//  30.105%  GET   /
-		//   6.702%  GET   /u/X
-		//   4.674%  GET   /comments[/X]
-		//   0.967%  GET   /recent[/X]
-		//   0.630%  POST  /comments/X/upvote
-		//   0.475%  POST  /stories/X/upvote
-		//   0.316%  POST  /comments
-		//   0.087%  POST  /login
-		//   0.071%  POST  /comments/X
-		//   0.054%  POST  /comments/X/downvote
-		//   0.053%  POST  /stories
-		//   0.021%  POST  /stories/X/downvote
-		//   0.003%  POST  /logout
-		if applies(55842, &seed) {
-			// /stories/X
-			return operations.Story{Ops: w.ops}
-		} else if applies(30105, &seed) {
-			// /
-			return operations.Frontpage{Ops: w.ops}
-		} else if applies(6702, &seed) {
-			// /u/X
-			continue
-		} else if applies(4674, &seed) {
-			// /comments[/X]
-			continue
-		} else if applies(967, &seed) {
-			// /recent[/X]
-			continue
-		} else if applies(630, &seed) {
-			// /comments/X/upvote
-			return operations.CommentVote{Ops: w.ops, Vote: 1}
-		} else if applies(475, &seed) {
-			// /stories/X/upvote
-			return operations.StoryVote{Ops: w.ops, Vote: 1}
-		} else if applies(316, &seed) {
-			// /comments
-			return operations.Comment{Ops: w.ops}
-		} else if applies(87, &seed) {
-			// /login
-			continue
-		} else if applies(71, &seed) {
-			// /comments/X
-			continue
-		} else if applies(54, &seed) {
-			// /comments/X/downvote
-			return operations.CommentVote{Ops: w.ops, Vote: -1}
-		} else if applies(53, &seed) {
-			// /stories
-			return operations.Submit{Ops: w.ops}
-		} else if applies(21, &seed) {
-			// /stories/X/downvote
-			return operations.StoryVote{Ops: w.ops, Vote: -1}
-		} else {
-			// /logout
-			continue
-		}
-	}
-	return operations.Frontpage{}
-}
-
-// Preload ...
-func (w Workload) Preload() error {
-	fmt.Println("Preloading ..")
-
-	w.ops.StoryID = 0
-
-	preadloadThreads := 10
-	var wg sync.WaitGroup
-
-	for t := 1; t <= preadloadThreads; t++ {
-		wg.Add(1)
-		go func(count int64) {
-			defer wg.Done()
-			for i := int64(0); i < count; i++ {
-				if err := w.ops.AddUser(); err != nil {
-					panic(err)
-				}
-			}
-		}(w.config.Preload.RecordCount.Users / int64(preadloadThreads))
-	}
-
-	wg.Wait()
-	fmt.Printf("Created %d users\n", w.config.Preload.RecordCount.Users)
-
-	for t := 1; t <= preadloadThreads; t++ {
-		wg.Add(1)
-		go func(count int64) {
-			defer wg.Done()
-			for i := int64(0); i < count; i++ {
-				if _, err := w.ops.Submit(); err != nil {
-					panic(err)
-				}
-			}
-
-		}(w.config.Preload.RecordCount.Stories / int64(preadloadThreads))
+func (w workloadComplete) nextOp(r *rand.Rand, at time.Duration) operations.Operation {
+	seed := r.Intn(100000)
+	// 	55.842%  GET   /stories/X
+	//  30.105%  GET   /
+	//   6.702%  GET   /u/X
+	//   4.674%  GET   /comments[/X]
+	//   0.967%  GET   /recent[/X]
+	//   0.630%  POST  /comments/X/upvote
+	//   0.475%  POST  /stories/X/upvote
+	//   0.316%  POST  /comments
+	//   0.087%  POST  /login
+	//   0.071%  POST  /comments/X
+	//   0.054%  POST  /comments/X/downvote
+	//   0.053%  POST  /stories
+	//   0.021%  POST  /stories/X/downvote
+	//   0.003%  POST  /logout
+	if applies(55842, &seed) {
+		// /stories/X
+		return operations.Story{Ops: w.ops, StoryID: w.ops.ReadStoryID(r, at)}
+	} else if applies(30105, &seed) {
+		// /
+		return operations.Frontpage{Ops: w.ops}
+	} else if applies(6702, &seed) {
+		// /u/X
+		return operations.User{Ops: w.ops, UserID: w.ops.RandomUser(r)}
+	} else if applies(4674, &seed) {
+		// /comments[/X]
+		return operations.Comments{Ops: w.ops}
+	} else if applies(967, &seed) {
+		// /recent[/X]
+		return operations.Recent{Ops: w.ops}
+	} else if applies(630, &seed) {
+		// /comments/X/upvote
+		return operations.CommentVote{Ops: w.ops, Vote: 1, CommentID: w.ops.VoteCommentID(r), UserID: w.ops.ActiveUser(r)}
+	} else if applies(475, &seed) {
+		// /stories/X/upvote
+		return operations.StoryVote{Ops: w.ops, Vote: 1, StoryID: w.ops.VoteStoryID(r, at), UserID: w.ops.ActiveUser(r)}
+	} else if applies(316, &seed) {
+		// /comments
+		return operations.Comment{Ops: w.ops, StoryID: w.ops.CommentStoryID(r, at), UserID: w.ops.ActiveUser(r), Text: operations.RandString(r, 20)}
+	} else if applies(87, &seed) {
+		// /login
+		return operations.Login{Ops: w.ops, UserID: w.ops.RandomUser(r)}
+	} else if applies(71, &seed) {
+		// /comments/X
+		return operations.EditComment{Ops: w.ops, CommentID: w.ops.VoteCommentID(r), Text: operations.RandString(r, 20)}
+	} else if applies(54, &seed) {
+		// /comments/X/downvote
+		return operations.CommentVote{Ops: w.ops, Vote: -1, CommentID: w.ops.VoteCommentID(r), UserID: w.ops.ActiveUser(r)}
+	} else if applies(53, &seed) {
+		// /stories
+		return operations.Submit{Ops: w.ops, UserID: w.ops.ActiveUser(r), Description: operations.RandString(r, 30), At: at}
+	} else if applies(21, &seed) {
+		// /stories/X/downvote
+		return operations.StoryVote{Ops: w.ops, Vote: -1, StoryID: w.ops.VoteStoryID(r, at), UserID: w.ops.ActiveUser(r)}
+	} else {
+		// /logout
+		return operations.Logout{Ops: w.ops}
 	}
-
-	wg.Wait()
-	fmt.Printf("Created %d stories\n", w.config.Preload.RecordCount.Stories)
-
-	for t := 1; t <= preadloadThreads; t++ {
-		wg.Add(1)
-		go func(count int64) {
-			defer wg.Done()
-			for i := int64(0); i < count; i++ {
-				if _, err := w.ops.Comment(); err != nil {
-					panic(err)
-				}
-			}
-		}(w.config.Preload.RecordCount.Comments / int64(preadloadThreads))
-	}
-
-	wg.Wait()
-	fmt.Printf("Created %d comments\n", w.config.Preload.RecordCount.Comments)
-
-	for t := 1; t <= preadloadThreads; t++ {
-		wg.Add(1)
-		go func(count int64) {
-			defer wg.Done()
-			for i := int64(0); i < count; i++ {
-				if _, err := w.ops.StoryVote(1, 0); err != nil {
-					panic(err)
-				}
-			}
-		}(w.config.Preload.RecordCount.Votes / int64(preadloadThreads))
-	}
-
-	wg.Wait()
-	fmt.Printf("Created %d votes\n", w.config.Preload.RecordCount.Votes)
-
-	fmt.Println("Preloading done")
-	return nil
 }
 
 // Test ...
 func (w Workload) Test() error {
+	r := w.rand(0)
+	ctx := context.Background()
+
 	fmt.Println("Submit Story ...")
-	if _, err := w.ops.Submit(); err != nil {
+	if _, err := w.ops.Submit(ctx, w.ops.ActiveUser(r), operations.RandString(r, 30), 0); err != nil {
 		return err
 	}
 
 	fmt.Println("GetHomepage ...")
-	_, err := w.ops.Frontpage(0)
+	_, err := w.ops.Frontpage(ctx, 0)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("UpVote story ...")
-	if _, err := w.ops.StoryVote(1, 0); err != nil {
+	if _, err := w.ops.StoryVote(ctx, w.ops.ActiveUser(r), w.ops.VoteStoryID(r, 0), 1, 0); err != nil {
+		return err
+	}
+	fmt.Println("UpVote comment ...")
+	if _, err := w.ops.CommentVote(ctx, w.ops.ActiveUser(r), w.ops.VoteCommentID(r), 1, 0); err != nil {
 		return err
 	}
 	time.Sleep(2 * time.Second)
 
 	fmt.Println("Get Homepage ...")
-	_, err = w.ops.Frontpage(0)
+	_, err = w.ops.Frontpage(ctx, 0)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Get story by storyID ...")
-	_, err = w.ops.Story()
+	_, err = w.ops.Story(ctx, w.ops.ReadStoryID(r, 0), 0)
 	if err != nil {
 		return err
 	}
 
+	fmt.Println("Get recent ...")
+	if _, err = w.ops.Recent(ctx, 0); err != nil {
+		return err
+	}
+
+	fmt.Println("Get comments ...")
+	if _, err = w.ops.Comments(ctx, 0); err != nil {
+		return err
+	}
+
+	fmt.Println("Get user profile ...")
+	if _, err = w.ops.User(ctx, w.ops.RandomUser(r), 0); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// rand returns the random source of the given preload thread.
+func (w Workload) rand(thread int) *rand.Rand {
+	return rand.New(rand.NewSource(w.config.Benchmark.Seed + int64(thread)))
+}
+
 // Close ...
 func (w Workload) Close() {
+	if w.recorder != nil {
+		if err := w.recorder.Close(); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("trace recording failed")
+		}
+	}
 	w.ops.Close()
 }
 